@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/db"
+)
+
+// ApiError is the body of every failed API response
+type ApiError struct {
+	Error ApiErrorDetail `json:"error"`
+}
+
+type ApiErrorDetail struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+type CreateRequestBody struct {
+	Address string `json:"address"`
+	Denom   string `json:"denom,omitempty"`
+}
+
+type RequestResponse struct {
+	ID        string           `json:"id"`
+	StatusURL string           `json:"status_url"`
+	Chain     string           `json:"chain"`
+	Address   string           `json:"address"`
+	Amount    string           `json:"amount"`
+	Status    db.ReceiptStatus `json:"status"`
+	TxHash    string           `json:"tx_hash,omitempty"`
+	Height    int64            `json:"height,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	// QueuePosition is only known when the request is created
+	QueuePosition int64 `json:"queue_position,omitempty"`
+}
+
+func newRequestResponse(receipt db.FundingReceipt) RequestResponse {
+	return RequestResponse{
+		ID:        receipt.ID,
+		StatusURL: requestsPath + receipt.ID,
+		Chain:     receipt.ChainPrefix,
+		Address:   receipt.Recipient,
+		Amount:    receipt.Amount.String(),
+		Status:    receipt.Status,
+		TxHash:    receipt.TxHash,
+		Height:    receipt.Height,
+		Error:     receipt.Error,
+		CreatedAt: receipt.FundedAt,
+	}
+}
+
+type ChainResponse struct {
+	Prefix          string `json:"prefix"`
+	Amount          string `json:"amount"`
+	Cooldown        string `json:"cooldown"`
+	CooldownSeconds int64  `json:"cooldown_seconds"`
+	Available       bool   `json:"available"`
+}
+
+const requestsPath = "/api/v1/requests/"
+
+// errorStatus maps request error codes to HTTP status codes
+var errorStatus = map[ErrorCode]int{
+	ErrInvalidAddress:   http.StatusUnprocessableEntity,
+	ErrUnsupportedChain: http.StatusNotFound,
+	ErrUnsupportedDenom: http.StatusUnprocessableEntity,
+	ErrNotEligible:      http.StatusForbidden,
+	ErrCooldown:         http.StatusTooManyRequests,
+	ErrFundingCap:       http.StatusForbidden,
+	ErrFaucetClosed:     http.StatusServiceUnavailable,
+	ErrFaucetBusy:       http.StatusServiceUnavailable,
+	ErrChainUnavailable: http.StatusServiceUnavailable,
+	ErrInternal:         http.StatusInternalServerError,
+}
+
+// apiRequests handles POST /api/v1/requests
+func (fh FaucetHandler) apiRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeApiError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed")
+		return
+	}
+	var body CreateRequestBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	address := strings.TrimSpace(body.Address)
+	if address == "" {
+		writeApiError(w, http.StatusUnprocessableEntity, ErrInvalidAddress, "address is required")
+		return
+	}
+
+	ip := clientIP(r)
+	log.Infof("api request from %s", ip)
+	queued, err := fh.dispense(DispenseRequest{
+		Address:   address,
+		Denom:     strings.TrimSpace(body.Denom),
+		Frontend:  db.FrontendHTTP,
+		Requester: ip,
+		ClientIP:  ip,
+	})
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	res := newRequestResponse(queued.FundingReceipt)
+	res.QueuePosition = queued.Position
+	w.Header().Set("Location", requestsPath+queued.ID)
+	writeJson(w, http.StatusAccepted, res)
+}
+
+// apiRequest handles GET /api/v1/requests/{id}
+func (fh FaucetHandler) apiRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, requestsPath)
+	receipt, err := fh.db.GetFundingReceipt(r.Context(), id)
+	if errors.Is(err, db.ErrReceiptNotFound) {
+		writeApiError(w, http.StatusNotFound, "request_not_found", "no request with id "+id)
+		return
+	}
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, newRequestResponse(*receipt))
+}
+
+// apiChains handles GET /api/v1/chains
+func (fh FaucetHandler) apiChains(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed")
+		return
+	}
+	chains := make([]ChainResponse, 0, len(fh.chains))
+	for _, c := range fh.chains {
+		cooldown := chainFundingInterval(c.Prefix)
+		chains = append(chains, ChainResponse{
+			Prefix:          c.Prefix,
+			Amount:          funding[c.Prefix].Coins,
+			Cooldown:        cooldown.String(),
+			CooldownSeconds: int64(cooldown.Seconds()),
+			Available:       c.Available(),
+		})
+	}
+	writeJson(w, http.StatusOK, chains)
+}
+
+func writeRequestError(w http.ResponseWriter, err error) {
+	code := errorCode(err)
+	setRetryAfter(w, err)
+	if code == ErrInternal {
+		log.Error(err)
+	}
+	writeApiError(w, errorStatus[code], code, err.Error())
+}
+
+// setRetryAfter tells clients when a rejected request can be retried
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) && reqErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reqErr.RetryAfter.Seconds()))))
+	}
+}
+
+func writeApiError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	writeJson(w, status, ApiError{ApiErrorDetail{code, message}})
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Error(fmt.Errorf("writing response: %w", err))
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/db"
+)
+
+const (
+	defaultBudgetWindow    = time.Hour * 24
+	defaultBreakerCooldown = time.Hour
+	// spikeWindow is compared against the hourly average of the budget window
+	spikeWindow = time.Hour
+)
+
+// FaucetClosedError is returned while a chain does not dispense, because its
+// budget is spent or its circuit breaker tripped
+type FaucetClosedError struct {
+	Prefix string
+	Reason string
+	Until  time.Time
+}
+
+func (e *FaucetClosedError) Error() string {
+	return fmt.Sprintf("the %s faucet is closed, %s. It reopens in %v", e.Prefix, e.Reason, time.Until(e.Until).Round(time.Minute))
+}
+
+// SpendGuard enforces the spend budget of each chain over a rolling window,
+// and trips a circuit breaker when dispense volume spikes far above the
+// recent baseline
+type SpendGuard struct {
+	store db.Store
+
+	mu           sync.Mutex
+	trippedUntil map[db.ChainPrefix]time.Time
+	// chains serializes the checks of each chain with the ledger appends
+	chains map[db.ChainPrefix]*sync.Mutex
+}
+
+func NewSpendGuard(store db.Store) *SpendGuard {
+	return &SpendGuard{
+		store:        store,
+		trippedUntil: make(map[db.ChainPrefix]time.Time),
+		chains:       make(map[db.ChainPrefix]*sync.Mutex),
+	}
+}
+
+// Check returns a *FaucetClosedError if dispensing coins on the chain would
+// exceed its budget, or if the chain's circuit breaker is tripped. Otherwise
+// it holds the chain until release is called, which must happen once the
+// dispense is in the ledger or was abandoned, so a burst of requests can't
+// overspend the budget.
+func (g *SpendGuard) Check(ctx context.Context, prefix string, coins cosmostypes.Coins) (release func(), err error) {
+	info := funding[prefix]
+	if info.Budget == "" && info.SpikeFactor <= 0 {
+		return func() {}, nil
+	}
+	lock := g.chainLock(prefix)
+	lock.Lock()
+	err = g.check(ctx, prefix, coins)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return lock.Unlock, nil
+}
+
+func (g *SpendGuard) chainLock(prefix string) *sync.Mutex {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	lock, ok := g.chains[prefix]
+	if !ok {
+		lock = new(sync.Mutex)
+		g.chains[prefix] = lock
+	}
+	return lock
+}
+
+func (g *SpendGuard) check(ctx context.Context, prefix string, coins cosmostypes.Coins) error {
+	info := funding[prefix]
+	now := time.Now()
+
+	g.mu.Lock()
+	until := g.trippedUntil[prefix]
+	g.mu.Unlock()
+	if until.After(now) {
+		return &FaucetClosedError{prefix, "dispense volume spiked", until}
+	}
+
+	window := info.BudgetWindow.Duration
+	if window <= 0 {
+		window = defaultBudgetWindow
+	}
+	receipts, err := g.store.ListFundingReceipts(ctx, db.ReceiptQuery{
+		ChainPrefix: prefix,
+		Since:       now.Add(-window),
+		Statuses:    activeStatuses,
+	})
+	if err != nil {
+		return err
+	}
+
+	if info.Budget != "" {
+		budget, err := cosmostypes.ParseCoinsNormalized(info.Budget)
+		if err != nil {
+			return err
+		}
+		spent := receipts.TotalAmount()
+		total := spent.Add(coins...)
+		for _, c := range budget {
+			if total.AmountOf(c.Denom).GT(c.Amount) {
+				log.Warnf("%s budget of %s per %v is spent (%s)", prefix, budget, window, spent)
+				return &FaucetClosedError{prefix, "its budget is spent", earliestFundedAt(receipts, now).Add(window)}
+			}
+		}
+	}
+
+	if info.SpikeFactor > 0 && window > spikeWindow {
+		var recent int
+		for _, r := range receipts {
+			if r.FundedAt.After(now.Add(-spikeWindow)) {
+				recent += 1
+			}
+		}
+		// hourly average of the budget window before the spike window
+		var baseline float64
+		if spikeWindows := float64(window-spikeWindow) / float64(spikeWindow); spikeWindows > 0 {
+			baseline = float64(len(receipts)-recent) / spikeWindows
+		}
+		if baseline < 1 {
+			baseline = 1
+		}
+		if float64(recent) > info.SpikeFactor*baseline {
+			cooldown := info.BreakerCooldown.Duration
+			if cooldown <= 0 {
+				cooldown = defaultBreakerCooldown
+			}
+			until := now.Add(cooldown)
+			g.mu.Lock()
+			g.trippedUntil[prefix] = until
+			g.mu.Unlock()
+			log.Warnf("%s circuit breaker tripped: %d dispenses in the last %v, baseline %.1f", prefix, recent, spikeWindow, baseline)
+			return &FaucetClosedError{prefix, "dispense volume spiked", until}
+		}
+	}
+	return nil
+}
+
+func earliestFundedAt(receipts db.FundingReceipts, now time.Time) time.Time {
+	earliest := now
+	for _, r := range receipts {
+		if r.FundedAt.Before(earliest) {
+			earliest = r.FundedAt
+		}
+	}
+	return earliest
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	"github.com/xiti922/fonzie/chain"
+	"github.com/xiti922/fonzie/db"
+)
+
+func TestSpendGuardSpikeShortWindow(t *testing.T) {
+	store := db.NewMemoryDb()
+	funding = ChainFunding{"umee": {
+		Coins:        "1000uumee",
+		BudgetWindow: chain.Duration{Duration: 90 * time.Minute},
+		SpikeFactor:  2,
+	}}
+	coins := cosmostypes.NewCoins(cosmostypes.NewInt64Coin("uumee", 1000))
+	for i := 0; i < 3; i++ {
+		_, err := store.AppendFundingReceipt(context.Background(), db.FundingReceipt{
+			ChainPrefix: "umee",
+			FundedAt:    time.Now(),
+			Amount:      coins,
+			Status:      db.ReceiptIncluded,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := NewSpendGuard(store).Check(context.Background(), "umee", coins)
+	var closed *FaucetClosedError
+	if !errors.As(err, &closed) {
+		t.Fatalf("Check() = %v, want the circuit breaker to trip", err)
+	}
+}
+
+func TestDispenseConcurrentBudget(t *testing.T) {
+	fh := newTestFaucetHandler(t, db.NewMemoryDb())
+	funding["umee"] = ChainFundingInfo{Coins: "1000uumee", Fees: "10uumee", Budget: "5000uumee"}
+
+	const n = 20
+	errs := make(chan error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		address := testAddress(t, byte(i+1))
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := fh.dispense(DispenseRequest{
+				Address:   address,
+				Frontend:  db.FrontendHTTP,
+				Requester: address,
+			})
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	var dispensed int
+	for err := range errs {
+		if err == nil {
+			dispensed += 1
+			continue
+		}
+		if code := errorCode(err); code != ErrFaucetClosed {
+			t.Errorf("dispense() error code = %s, want %s: %v", code, ErrFaucetClosed, err)
+		}
+	}
+	if dispensed != 5 {
+		t.Errorf("%d requests were dispensed within a budget for 5", dispensed)
+	}
+}
@@ -2,9 +2,12 @@ package chain
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	cosmostypes "github.com/cosmos/cosmos-sdk/types"
 	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
@@ -16,16 +19,6 @@ import (
 
 type Chains []*Chain
 
-func (chains Chains) ImportMnemonic(ctx context.Context, mnemonic string) error {
-	for _, info := range chains {
-		err := info.ImportMnemonic(mnemonic)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (chains Chains) FindByPrefix(prefix string) *Chain {
 	for _, info := range chains {
 		if info.Prefix == prefix {
@@ -36,111 +29,179 @@ func (chains Chains) FindByPrefix(prefix string) *Chain {
 }
 
 type Chain struct {
-	Prefix   string                        `json:"prefix"`
-	RPC      string                        `json:"rpc"`
-	CoinType uint32                        `json:"coin_type"`
-	client   *customlens.CustomChainClient `json:"-"`
+	Prefix    string `json:"prefix"`
+	RPC       string `json:"rpc"`
+	CoinType  uint32 `json:"coin_type"`
+	QueueSize int    `json:"queue_size"`
+	// the worker flushes a batch after BatchWindow, or once it holds
+	// BatchSize requests or needs more than BatchGas, whichever comes first
+	BatchWindow Duration `json:"batch_window"`
+	BatchSize   int      `json:"batch_size"`
+	BatchGas    uint64   `json:"batch_gas"`
+	// InclusionTimeout is how long to wait for a broadcast tx to be committed
+	InclusionTimeout Duration `json:"inclusion_timeout"`
+	// SignerIndices are the HD address indices of sub-accounts that sign
+	// batches next to the primary account at index 0
+	SignerIndices []uint32 `json:"signers"`
+
+	// mu guards the client and signers, which are set once the chain is
+	// connected
+	mu      sync.RWMutex
+	client  *customlens.CustomChainClient `json:"-"`
+	signers []*Signer
+	ready   chan struct{}
 }
 
-func (chain *Chain) getClient() *customlens.CustomChainClient {
+func (chain *Chain) getClient() (*customlens.CustomChainClient, error) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
 	if chain.client == nil {
-		if chain.CoinType == 0 {
-			// default to cosmos
-			chain.CoinType = 118
-		}
-		chainID, err := getChainID(chain.RPC)
-		if err != nil {
-			log.Fatalf("failed to get chain id for %s. err: %v", chain.Prefix, err)
-		}
-		log.Infof("chain id for %s is %s", chain.Prefix, chainID)
+		return nil, fmt.Errorf("%s: %w", chain.Prefix, ErrUnavailable)
+	}
+	return chain.client, nil
+}
 
-		// calculate gas adjustment from env
-		gasAdjustment, err := strconv.ParseFloat(os.Getenv("GAS_ADJUSTMENT"), 64)
-		if err != nil {
-			gasAdjustment = 1.5
-		}
-		log.Infof("gas adjustment is %f", gasAdjustment)
-
-		// Build chain config
-		chainConfig := lens.ChainClientConfig{
-			Key:            "anon",
-			ChainID:        chainID,
-			RPCAddr:        chain.RPC,
-			AccountPrefix:  chain.Prefix,
-			KeyringBackend: "memory",
-			GasAdjustment:  gasAdjustment,
-			Debug:          true,
-			Timeout:        "5s",
-			OutputFormat:   "json",
-			SignModeStr:    "direct",
-			Modules:        lens.ModuleBasics,
-		}
-		chainConfig.Key = "anon"
+// newClient creates a client with its own keyring, so each signer can use
+// the key name "anon"
+func (chain *Chain) newClient(chainID string) (*customlens.CustomChainClient, error) {
+	// calculate gas adjustment from env
+	gasAdjustment, err := strconv.ParseFloat(os.Getenv("GAS_ADJUSTMENT"), 64)
+	if err != nil {
+		gasAdjustment = 1.5
+	}
+	log.Infof("gas adjustment is %f", gasAdjustment)
 
-		// Creates client object to pull chain info
-		c, err := lens.NewChainClient(&chainConfig, "", os.Stdin, os.Stdout)
-		if err != nil {
-			log.Fatal(err)
-		}
+	// Build chain config
+	chainConfig := lens.ChainClientConfig{
+		Key:            "anon",
+		ChainID:        chainID,
+		RPCAddr:        chain.RPC,
+		AccountPrefix:  chain.Prefix,
+		KeyringBackend: "memory",
+		GasAdjustment:  gasAdjustment,
+		Debug:          true,
+		Timeout:        "5s",
+		OutputFormat:   "json",
+		SignModeStr:    "direct",
+		Modules:        lens.ModuleBasics,
+	}
+	chainConfig.Key = "anon"
 
-		chain.client = &customlens.CustomChainClient{ChainClient: c}
+	// Creates client object to pull chain info
+	c, err := lens.NewChainClient(&chainConfig, "", os.Stdin, os.Stdout)
+	if err != nil {
+		return nil, err
 	}
-	return chain.client
+
+	return customlens.NewCustomChainClient(c), nil
 }
 
-func (chain *Chain) ImportMnemonic(mnemonic string) error {
-	_, err := chain.getClient().KeyAddOrRestore("anon", chain.CoinType, mnemonic)
+// ChainID is the id reported by the chain's RPC node
+func (chain *Chain) ChainID() string {
+	c, err := chain.getClient()
 	if err != nil {
-		return err
+		return ""
 	}
-	return nil
+	return c.Config.ChainID
 }
 
-func (chain Chain) MultiSend(toAddr []cosmostypes.AccAddress, coins []cosmostypes.Coins, fees cosmostypes.Coins) error {
-	c := chain.getClient()
+// FaucetAddress is the bech32 address the faucet dispenses from
+func (chain *Chain) FaucetAddress() (string, error) {
+	c, err := chain.getClient()
+	if err != nil {
+		return "", err
+	}
 	faucetRawAddr, err := c.GetKeyAddress()
 	if err != nil {
-		return err
+		return "", err
+	}
+	return c.EncodeBech32AccAddr(faucetRawAddr)
+}
+
+// Balance queries the spendable balance of an address
+func (chain *Chain) Balance(ctx context.Context, address string) (cosmostypes.Coins, error) {
+	c, err := chain.getClient()
+	if err != nil {
+		return nil, err
 	}
-	faucetAddrStr, err := c.EncodeBech32AccAddr(faucetRawAddr)
+	res, err := banktypes.NewQueryClient(c).AllBalances(ctx, &banktypes.QueryAllBalancesRequest{
+		Address:    address,
+		Pagination: lens.DefaultPageRequest(),
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return res.Balances, nil
+}
+
+// MultiSend sends coins from the account of the signer. onSigned is called
+// with the hash of every signed tx before it is broadcast, it is optional.
+func (chain *Chain) MultiSend(signer *Signer, toAddr []cosmostypes.AccAddress, coins []cosmostypes.Coins, fees cosmostypes.Coins, onSigned customlens.SignedFunc) (*cosmostypes.TxResponse, error) {
+	req, err := chain.multiSendMsg(signer.Address, toAddr, coins)
+	if err != nil {
+		return nil, err
+	}
+	return chain.sendMsg(req, fees, signer.client, onSigned)
+}
 
+// EstimateMultiSendGas simulates a MultiSend and returns the gas it would use
+func (chain *Chain) EstimateMultiSendGas(toAddr []cosmostypes.AccAddress, coins []cosmostypes.Coins) (uint64, error) {
+	faucetAddrStr, err := chain.FaucetAddress()
+	if err != nil {
+		return 0, err
+	}
+	req, err := chain.multiSendMsg(faucetAddrStr, toAddr, coins)
+	if err != nil {
+		return 0, err
+	}
+	c, err := chain.getClient()
+	if err != nil {
+		return 0, err
+	}
+	return c.EstimateGas([]cosmostypes.Msg{req})
+}
+
+func (chain *Chain) multiSendMsg(faucetAddrStr string, toAddr []cosmostypes.AccAddress, coins []cosmostypes.Coins) (*banktypes.MsgMultiSend, error) {
+	c, err := chain.getClient()
+	if err != nil {
+		return nil, err
+	}
 	var inputs []banktypes.Input
 	var outputs []banktypes.Output
 	for i := range toAddr {
 		recipient, err := c.EncodeBech32AccAddr(toAddr[i])
 		if err != nil {
-			return err
+			return nil, err
 		}
 		log.Infof("Multi sending %s from faucet address [%s] to recipient [%s]",
 			coins[i], faucetAddrStr, recipient)
 		inputs = append(inputs, banktypes.Input{Address: faucetAddrStr, Coins: coins[i]})
 		outputs = append(outputs, banktypes.Output{Address: recipient, Coins: coins[i]})
 	}
-	req := &banktypes.MsgMultiSend{
+	return &banktypes.MsgMultiSend{
 		Inputs:  inputs,
 		Outputs: outputs,
-	}
-
-	return chain.sendMsg(req, fees, c)
+	}, nil
 }
 
-func (chain Chain) DecodeAddr(a string) (cosmostypes.AccAddress, error) {
-	c := chain.getClient()
-	return c.DecodeBech32AccAddr(a)
+// DecodeAddr decodes an account address of the chain, it works while the
+// chain is unavailable
+func (chain *Chain) DecodeAddr(a string) (cosmostypes.AccAddress, error) {
+	return cosmostypes.GetFromBech32(a, chain.Prefix)
 }
 
-func (chain Chain) Send(toAddr string, coins cosmostypes.Coins, fees cosmostypes.Coins) error {
-	c := chain.getClient()
+func (chain *Chain) Send(toAddr string, coins cosmostypes.Coins, fees cosmostypes.Coins) (*cosmostypes.TxResponse, error) {
+	c, err := chain.getClient()
+	if err != nil {
+		return nil, err
+	}
 	faucetRawAddr, err := c.GetKeyAddress()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	faucetAddr, err := c.EncodeBech32AccAddr(faucetRawAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	log.Infof("Sending %s from faucet address [%s] to recipient [%s]", coins, faucetAddr, toAddr)
@@ -150,39 +211,103 @@ func (chain Chain) Send(toAddr string, coins cosmostypes.Coins, fees cosmostypes
 		Amount:      coins,
 	}
 
-	return chain.sendMsg(req, fees, c)
+	return chain.sendMsg(req, fees, c, nil)
+}
+
+func (chain *Chain) sendMsg(msg cosmostypes.Msg, fees cosmostypes.Coins, c *customlens.CustomChainClient, onSigned customlens.SignedFunc) (*cosmostypes.TxResponse, error) {
+	delay := sendRetryDelay
+	for attempt := 1; ; attempt++ {
+		// every attempt signs the message again, after a sequence mismatch
+		// with the sequence queried from the chain. SendMsg only fails for
+		// txs that were rejected or not broadcast, so nothing is sent twice.
+		res, err := c.SendMsg(context.Background(), msg, fees.String(), onSigned)
+		if err == nil {
+			fmt.Println(c.PrintTxResponse(res))
+			return res, nil
+		}
+		if !isTransient(res, err) {
+			return res, err
+		}
+		if attempt == sendAttempts {
+			return res, &TransientError{Attempts: attempt, Err: err}
+		}
+		log.Warnf("%s send attempt %d failed, retrying in %s: %v", chain.Prefix, attempt, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// ErrNotIncluded is returned when a broadcast tx was not committed in time.
+// It may still be committed later.
+var ErrNotIncluded = errors.New("transaction was not included in a block")
+
+const defaultInclusionTimeout = time.Minute
+
+// WaitForTx waits until a broadcast tx is committed in a block
+func (chain *Chain) WaitForTx(hash string) (*cosmostypes.TxResponse, error) {
+	return chain.waitForTx(context.Background(), hash)
 }
 
-func (chain Chain) sendMsg(msg cosmostypes.Msg, fees cosmostypes.Coins, c *customlens.CustomChainClient) error {
-	res, err := c.SendMsg(context.Background(), msg, fees.String())
+func (chain *Chain) waitForTx(ctx context.Context, hash string) (*cosmostypes.TxResponse, error) {
+	timeout := chain.InclusionTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultInclusionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c, err := chain.getClient()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.WaitForTx(ctx, hash)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: tx %s within %s", ErrNotIncluded, hash, timeout)
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return txResult(c, res)
+}
+
+// txResult returns an error for a committed tx that failed
+func txResult(c *customlens.CustomChainClient, res *cosmostypes.TxResponse) (*cosmostypes.TxResponse, error) {
+	if res.Code != 0 {
+		return res, fmt.Errorf("transaction %s failed with code %d: %s", res.TxHash, res.Code, res.RawLog)
 	}
 	fmt.Println(c.PrintTxResponse(res))
-	return nil
+	return res, nil
+}
+
+type commitResponse struct {
+	Result struct {
+		SignedHeader struct {
+			Header struct {
+				ChainID string `json:"chain_id"`
+			} `json:"header"`
+		} `json:"signed_header"`
+	} `json:"result"`
 }
 
-func getChainID(rpcUrl string) (string, error) {
+func getChainID(ctx context.Context, rpcUrl string) (string, error) {
 	rpc := resty.New().SetBaseURL(rpcUrl)
 
 	resp, err := rpc.R().
-		SetResult(map[string]interface{}{}).
-		SetError(map[string]interface{}{}).
+		SetContext(ctx).
+		SetResult(&commitResponse{}).
 		Get("/commit")
 	if err != nil {
 		return "", err
 	}
 
 	if resp.IsError() {
-		//return "", resp.Error().(*map[string]interface{})
 		return "", fmt.Errorf("could not get chain id; http error code received %d", resp.StatusCode())
 	}
 
-	respbody := resp.Result().(*map[string]interface{})
-	result := (*respbody)["result"]
-	signedHeader := result.(map[string]interface{})["signed_header"]
-	header := signedHeader.(map[string]interface{})["header"]
-	chainID := header.(map[string]interface{})["chain_id"].(string)
+	chainID := resp.Result().(*commitResponse).Result.SignedHeader.Header.ChainID
+	if chainID == "" {
+		return "", errors.New("could not get chain id; unexpected /commit response")
+	}
 	return chainID, nil
 }
 
@@ -0,0 +1,71 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// newTestChain connects a chain whose RPC node answers /tx with txError
+func newTestChain(t *testing.T, txError string) *Chain {
+	t.Helper()
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/commit" {
+			fmt.Fprint(w, `{"result":{"signed_header":{"header":{"chain_id":"umee-test"}}}}`)
+			return
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "tx" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":-32603,"message":"Internal error","data":%q}}`, req.ID, txError)
+	}))
+	t.Cleanup(rpc.Close)
+
+	c := &Chain{Prefix: "umee", RPC: rpc.URL, InclusionTimeout: Duration{2 * time.Second}}
+	if unavailable := (Chains{c}).Connect(context.Background(), testMnemonic, 5*time.Second); len(unavailable) > 0 {
+		t.Fatal("test chain is unavailable")
+	}
+	return c
+}
+
+func TestWaitForTx(t *testing.T) {
+	const hash = "AB12"
+	tests := []struct {
+		name    string
+		txError string
+		// notIncluded is whether WaitForTx keeps polling until the timeout
+		notIncluded bool
+	}{
+		{"not found", fmt.Sprintf("tx (%s) not found", hash), true},
+		{"indexing disabled", "transaction indexing is disabled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChain(t, tt.txError)
+			start := time.Now()
+			_, err := c.WaitForTx(hash)
+			if err == nil {
+				t.Fatal("WaitForTx() = nil, want an error")
+			}
+			if errors.Is(err, ErrNotIncluded) != tt.notIncluded {
+				t.Errorf("WaitForTx() = %v, want ErrNotIncluded: %v", err, tt.notIncluded)
+			}
+			if !tt.notIncluded && time.Since(start) > time.Second {
+				t.Errorf("WaitForTx() took %s, want it to fail right away", time.Since(start))
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnavailable is returned while the chain's RPC could not be reached
+var ErrUnavailable = errors.New("chain is unavailable")
+
+const (
+	reconnectDelay    = time.Second * 15
+	maxReconnectDelay = time.Minute * 5
+)
+
+// Connect connects to all chains in parallel, giving each timeout to
+// answer. It returns the chains that stay unavailable.
+func (chains Chains) Connect(ctx context.Context, mnemonic string, timeout time.Duration) Chains {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var unavailable Chains
+	for _, c := range chains {
+		wg.Add(1)
+		go func(c *Chain) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			err := c.Connect(ctx, mnemonic)
+			if err != nil {
+				log.Errorf("%s is unavailable: %v", c.Prefix, err)
+				mu.Lock()
+				unavailable = append(unavailable, c)
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	return unavailable
+}
+
+// Unavailable returns the chains that are not connected
+func (chains Chains) Unavailable() Chains {
+	var unavailable Chains
+	for _, c := range chains {
+		if !c.Available() {
+			unavailable = append(unavailable, c)
+		}
+	}
+	return unavailable
+}
+
+// Connect queries the chain id from the RPC and restores the primary
+// account and the sub-accounts of the signers from the mnemonic
+func (chain *Chain) Connect(ctx context.Context, mnemonic string) error {
+	if chain.Available() {
+		return nil
+	}
+	coinType := chain.CoinType
+	if coinType == 0 {
+		// default to cosmos
+		coinType = 118
+	}
+	chainID, err := getChainID(ctx, chain.RPC)
+	if err != nil {
+		return fmt.Errorf("failed to get chain id for %s. err: %w", chain.Prefix, err)
+	}
+	log.Infof("chain id for %s is %s", chain.Prefix, chainID)
+
+	var signers []*Signer
+	for _, index := range append([]uint32{0}, chain.SignerIndices...) {
+		if findSigner(signers, index) != nil {
+			continue
+		}
+		// every signer gets its own client, so each can use the key name "anon"
+		c, err := chain.newClient(chainID)
+		if err != nil {
+			return err
+		}
+		address, err := c.RestoreKey("anon", coinType, index, mnemonic)
+		if err != nil {
+			return err
+		}
+		signers = append(signers, &Signer{Index: index, Address: address, client: c})
+	}
+
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	if chain.client != nil {
+		// connected by a concurrent attempt
+		return nil
+	}
+	chain.client = signers[0].client
+	chain.signers = signers
+	if chain.ready == nil {
+		chain.ready = make(chan struct{})
+	}
+	close(chain.ready)
+	log.Infof("%s has %d signers", chain.Prefix, len(signers))
+	return nil
+}
+
+// KeepConnecting retries to connect to the chain in the background until it
+// succeeds or ctx is done
+func (chain *Chain) KeepConnecting(ctx context.Context, mnemonic string, timeout time.Duration) {
+	delay := reconnectDelay
+	for !chain.Available() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := chain.Connect(attemptCtx, mnemonic)
+		cancel()
+		if err != nil {
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			log.Warnf("%s is still unavailable, retrying in %s: %v", chain.Prefix, delay, err)
+			continue
+		}
+		log.Infof("%s is available again", chain.Prefix)
+	}
+}
+
+// Available reports whether the chain is connected
+func (chain *Chain) Available() bool {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return chain.client != nil
+}
+
+// Ready is closed once the chain is connected
+func (chain *Chain) Ready() <-chan struct{} {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	if chain.ready == nil {
+		chain.ready = make(chan struct{})
+	}
+	return chain.ready
+}
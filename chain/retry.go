@@ -0,0 +1,137 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+const (
+	sendAttempts   = 5
+	sendRetryDelay = time.Second * 2
+)
+
+// TransientError is returned when a transaction kept failing with errors
+// that usually clear up on their own, like a full mempool or RPC timeouts
+type TransientError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("%v (gave up after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// transientMessages are parts of errors from the RPC node or the tx
+// service that do not say anything about the transaction itself
+var transientMessages = []string{
+	"account sequence mismatch",
+	"incorrect account sequence",
+	"mempool is full",
+	"timed out",
+	"timeout",
+	"deadline exceeded",
+	"connection refused",
+	"connection reset",
+	"unexpected eof",
+	"internal server error",
+	"bad gateway",
+	"service unavailable",
+	"gateway timeout",
+}
+
+// isTransient reports whether a failed transaction may succeed when it is
+// signed and sent again
+func isTransient(res *cosmostypes.TxResponse, err error) bool {
+	if res != nil && res.Code != 0 {
+		if res.Codespace != sdkerrors.RootCodespace {
+			return false
+		}
+		return res.Code == sdkerrors.ErrWrongSequence.ABCICode() || res.Code == sdkerrors.ErrMempoolIsFull.ABCICode()
+	}
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, sdkerrors.ErrWrongSequence) || errors.Is(err, sdkerrors.ErrMempoolIsFull) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	if strings.HasSuffix(msg, "eof") {
+		return true
+	}
+	for _, transient := range transientMessages {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// recipientErrors are the failures a single output of a MultiSend can cause,
+// e.g. a module account that must not receive funds. Out of gas is included
+// since a smaller batch needs less gas.
+var recipientErrors = []*sdkerrors.Error{
+	sdkerrors.ErrUnauthorized,
+	sdkerrors.ErrInvalidAddress,
+	sdkerrors.ErrInvalidCoins,
+	sdkerrors.ErrOutOfGas,
+}
+
+// blockedRecipientMessage is how the bank module rejects an output to an
+// address that must not receive funds. The ante handler returns the same
+// ErrUnauthorized when the faucet's signature can't be verified.
+const blockedRecipientMessage = "is not allowed to receive"
+
+// IsRecipientError reports whether a failed transaction may have failed
+// because of one of its recipients, rather than the faucet account or node
+func IsRecipientError(res *cosmostypes.TxResponse, err error) bool {
+	if res != nil && res.Code != 0 {
+		if res.Codespace != sdkerrors.RootCodespace {
+			return false
+		}
+		for _, recipientErr := range recipientErrors {
+			if res.Code == recipientErr.ABCICode() {
+				return isRecipientLog(recipientErr, strings.ToLower(res.RawLog))
+			}
+		}
+		return false
+	}
+	if err == nil {
+		return false
+	}
+	var transient *TransientError
+	if errors.As(err, &transient) {
+		return false
+	}
+	// simulation errors only carry the message of the error
+	msg := strings.ToLower(err.Error())
+	for _, recipientErr := range recipientErrors {
+		if (errors.Is(err, recipientErr) || strings.Contains(msg, recipientErr.Error())) && isRecipientLog(recipientErr, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRecipientLog tells an unauthorized recipient from a faucet-side
+// unauthorized error, like a failed signature verification
+func isRecipientLog(recipientErr *sdkerrors.Error, log string) bool {
+	if recipientErr != sdkerrors.ErrUnauthorized {
+		return true
+	}
+	return strings.Contains(log, blockedRecipientMessage)
+}
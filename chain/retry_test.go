@@ -0,0 +1,40 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+func TestIsRecipientError(t *testing.T) {
+	failed := func(err error) *cosmostypes.TxResponse {
+		codespace, code, log := sdkerrors.ABCIInfo(err, false)
+		return &cosmostypes.TxResponse{Codespace: codespace, Code: code, RawLog: log}
+	}
+	tests := []struct {
+		name string
+		res  *cosmostypes.TxResponse
+		err  error
+		want bool
+	}{
+		{"blocked recipient", failed(sdkerrors.Wrapf(sdkerrors.ErrUnauthorized, "umee1xyz is not allowed to receive funds")), errors.New("transaction failed with code: 4"), true},
+		{"signature verification failed", failed(sdkerrors.Wrap(sdkerrors.ErrUnauthorized, "signature verification failed; please verify account number (0) and chain-id (umee-1)")), errors.New("transaction failed with code: 4"), false},
+		{"simulated signature verification failed", nil, errors.New("rpc error: code = Unknown desc = signature verification failed; please verify account number (0) and chain-id (umee-1): unauthorized"), false},
+		{"out of gas", failed(sdkerrors.ErrOutOfGas), errors.New("transaction failed with code: 11"), true},
+		{"insufficient funds", failed(sdkerrors.ErrInsufficientFunds), errors.New("transaction failed with code: 5"), false},
+		{"insufficient fee", failed(sdkerrors.ErrInsufficientFee), errors.New("transaction failed with code: 13"), false},
+		{"simulated blocked recipient", nil, errors.New("rpc error: code = Unknown desc = umee1xyz is not allowed to receive funds: unauthorized"), true},
+		{"simulated insufficient funds", nil, errors.New("rpc error: code = Unknown desc = 10uumee is smaller than 1000uumee: insufficient funds"), false},
+		{"transient", nil, &TransientError{Attempts: 5, Err: errors.New("timed out")}, false},
+		{"success", &cosmostypes.TxResponse{}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRecipientError(tt.res, tt.err); got != tt.want {
+				t.Errorf("IsRecipientError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
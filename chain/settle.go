@@ -0,0 +1,99 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/customlens"
+)
+
+// ErrNotIncludable is returned for a tx that was not committed while its
+// signer's sequence moved past the sequence of the tx, so it never will be
+var ErrNotIncludable = errors.New("transaction can no longer be included in a block")
+
+const (
+	settleRetryDelay = time.Second * 10
+	// settleIndexDelay is how long a tx may take to be indexed after its
+	// block was committed
+	settleIndexDelay = time.Second * 3
+)
+
+// SettleTx waits until a broadcast tx is committed, like WaitForTx, but it
+// only gives up on the tx once it provably can't be committed anymore. Until
+// then the tx is broadcast again from its bytes in case the node dropped it.
+// It returns ctx.Err() when ctx is done first.
+func (chain *Chain) SettleTx(ctx context.Context, signer string, tx customlens.SignedTx) (*cosmostypes.TxResponse, error) {
+	for {
+		res, err := chain.waitForTx(ctx, tx.Hash)
+		if err == nil || res != nil {
+			return res, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, ErrNotIncluded) {
+			res, err = chain.checkIncludable(ctx, signer, tx)
+			if err == nil || res != nil || errors.Is(err, ErrNotIncludable) {
+				return res, err
+			}
+		}
+		log.Warnf("%s tx %s is still pending: %v", chain.Prefix, tx.Hash, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(settleRetryDelay):
+		}
+	}
+}
+
+// checkIncludable looks for a tx that was not committed in time once the
+// sequence of its signer moved past it. It returns ErrNotIncludable when
+// the tx is still not found, and broadcasts it again while the sequence was
+// not used yet.
+func (chain *Chain) checkIncludable(ctx context.Context, signer string, tx customlens.SignedTx) (*cosmostypes.TxResponse, error) {
+	c, err := chain.getClient()
+	if err != nil {
+		return nil, err
+	}
+	address, err := chain.DecodeAddr(signer)
+	if err != nil {
+		return nil, err
+	}
+	sequence, err := c.AccountSequence(address)
+	if err != nil {
+		return nil, err
+	}
+	if sequence <= tx.Sequence {
+		if len(tx.Bytes) == 0 {
+			return nil, fmt.Errorf("%w: tx %s", ErrNotIncluded, tx.Hash)
+		}
+		res, err := c.BroadcastTx(ctx, tx.Bytes)
+		if err == nil && res.Code != 0 && !(res.Codespace == sdkerrors.RootCodespace && res.Code == sdkerrors.ErrTxInMempoolCache.ABCICode()) {
+			err = fmt.Errorf("broadcasting again failed with code %d: %s", res.Code, res.RawLog)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tx %s with sequence %d: %w", tx.Hash, tx.Sequence, err)
+		}
+		return nil, fmt.Errorf("%w: tx %s was broadcast again", ErrNotIncluded, tx.Hash)
+	}
+
+	// the sequence was used, by this tx or another one
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(settleIndexDelay):
+	}
+	res, err := c.QueryTx(ctx, tx.Hash)
+	if errors.Is(err, customlens.ErrTxNotFound) {
+		return nil, fmt.Errorf("%w: tx %s, %s is at sequence %d", ErrNotIncludable, tx.Hash, signer, sequence)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return txResult(c, res)
+}
@@ -0,0 +1,31 @@
+package chain
+
+import (
+	"github.com/xiti922/fonzie/customlens"
+)
+
+// Signer is an account derived from the faucet mnemonic that signs batches.
+// Every signer has its own client and account sequence, so its batches
+// don't wait on the txs of the other signers.
+type Signer struct {
+	// Index is the HD address index of the account, 0 is the primary account
+	Index   uint32
+	Address string
+	client  *customlens.CustomChainClient
+}
+
+// Signers returns the primary account followed by the sub-accounts
+func (chain *Chain) Signers() []*Signer {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return chain.signers
+}
+
+func findSigner(signers []*Signer, index uint32) *Signer {
+	for _, s := range signers {
+		if s.Index == index {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/db"
+)
+
+var applicationCommands = []*discordgo.ApplicationCommand{
+	{
+		Name:        "request",
+		Description: "Request coins through the faucet",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "address",
+				Description: "Address to fund, its bech32 prefix selects the chain",
+				Required:    true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "denom",
+				Description: "Only request this denom",
+			},
+		},
+	},
+	{
+		Name:        "help",
+		Description: "Show supported chains and commands",
+	},
+	{
+		Name:        "status",
+		Description: "Show when you can request funds again on each chain",
+	},
+}
+
+// registerCommands registers the slash commands of the bot globally
+func registerCommands(s *discordgo.Session, appID string) error {
+	_, err := s.ApplicationCommandBulkOverwrite(appID, "", applicationCommands)
+	return err
+}
+
+var deferredEphemeralResponse = discordgo.InteractionResponse{
+	Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	Data: &discordgo.InteractionResponseData{
+		Flags: uint64(discordgo.MessageFlagsEphemeral),
+	},
+}
+
+// handleInteraction is called for every interaction the bot receives over
+// the gateway
+func (fh FaucetHandler) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	// acknowledge right away, Discord only waits 3 seconds for a response
+	err := s.InteractionRespond(i.Interaction, &deferredEphemeralResponse)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	fh.completeInteraction(s, i.Interaction)
+}
+
+// completeInteraction runs the command of an acknowledged interaction and
+// replaces the deferred response with its reply
+func (fh FaucetHandler) completeInteraction(s *discordgo.Session, i *discordgo.Interaction) {
+	content := fh.runCommand(s, i)
+	_, err := s.InteractionResponseEdit(i, &discordgo.WebhookEdit{
+		Content: content,
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// runCommand executes a slash command and returns the reply
+func (fh FaucetHandler) runCommand(s *discordgo.Session, i *discordgo.Interaction) string {
+	user := interactionUser(i)
+	data := i.ApplicationCommandData()
+	switch data.Name {
+	case "request":
+		var address, denom string
+		for _, option := range data.Options {
+			switch option.Name {
+			case "address":
+				address = strings.TrimSpace(option.StringValue())
+			case "denom":
+				denom = strings.TrimSpace(option.StringValue())
+			}
+		}
+		queued, err := fh.dispense(DispenseRequest{
+			Address:   address,
+			Denom:     denom,
+			Frontend:  db.FrontendDiscord,
+			Requester: user.ID,
+			Discord: &DiscordRequester{
+				session: s,
+				guildID: i.GuildID,
+				userID:  user.ID,
+				member:  i.Member,
+			},
+			notifier: interactionNotifier{s, i},
+		})
+		if err != nil {
+			return fmt.Sprintf("❌ there is an error in your request:\n `%s`", err)
+		}
+		return fmt.Sprintf("👍 `%s` is on its way to `%s`, your request is #%d in the queue", queued.Amount, queued.Recipient, queued.Position)
+	case "status":
+		return fh.statusText(&DiscordRequester{
+			session: s,
+			guildID: i.GuildID,
+			userID:  user.ID,
+			member:  i.Member,
+		})
+	default:
+		return helpText(fh.chains)
+	}
+}
+
+// statusText lists for each chain what the user gets and when they can
+// request funds again, which is when their cooldown reservation expires
+func (fh FaucetHandler) statusText(d *DiscordRequester) string {
+	lines := []string{"**Faucet status**"}
+	for _, c := range fh.chains {
+		prefix := c.Prefix
+		tier, err := fh.discordTier(d, prefix)
+		if err != nil {
+			// not eligible on this chain, show its default funding
+			tier = ""
+		}
+		coins, interval, err := tierFunding(prefix, tier)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		line := fmt.Sprintf("• `%s`: %s every %v", prefix, coins, interval)
+		if !c.Available() {
+			lines = append(lines, line+", currently unavailable")
+			continue
+		}
+		until, err := fh.limiter.ReservedUntil(fh.ctx, prefix, RateLimitKey{RateLimitIdentity, discordIdentity(d.userID)})
+		if err != nil {
+			log.Error(err)
+			lines = append(lines, line)
+			continue
+		}
+		if !until.IsZero() {
+			line += fmt.Sprintf(", you can request again in %v", time.Until(until).Round(time.Minute))
+		} else {
+			line += ", you can request now"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func interactionUser(i *discordgo.Interaction) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// interactionNotifier reports the outcome of a slash command request with
+// an ephemeral follow-up message
+type interactionNotifier struct {
+	session     *discordgo.Session
+	interaction *discordgo.Interaction
+}
+
+func (n interactionNotifier) Dispensed(r FaucetReq, res *cosmostypes.TxResponse) {
+	n.followup(fmt.Sprintf("✅ Dispensed 💸 `%s` to `%s` in block %d, tx `%s`", r.Coins, r.Address, res.Height, res.TxHash))
+}
+
+func (n interactionNotifier) Failed(r FaucetReq, err error) {
+	n.followup(fmt.Sprintf("❌ there is an error in your request:\n `%s`", err))
+}
+
+func (n interactionNotifier) followup(content string) {
+	_, err := n.session.FollowupMessageCreate(n.interaction, false, &discordgo.WebhookParams{
+		Content: content,
+		Flags:   uint64(discordgo.MessageFlagsEphemeral),
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cosmos/btcutil/bech32"
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/chain"
+	"github.com/xiti922/fonzie/db"
+)
+
+// The @cosmjs/faucet protocol serves a single chain, so every chain gets its
+// own /<prefix>/credit and /<prefix>/status routes. The unprefixed routes pick
+// the chain by the address prefix, or by the prefix query parameter.
+
+type CosmjsCreditBody struct {
+	Address string `json:"address"`
+	Denom   string `json:"denom"`
+}
+
+type CosmjsStatus struct {
+	Status          string          `json:"status"`
+	NodeURL         string          `json:"nodeUrl"`
+	ChainID         string          `json:"chainId"`
+	ChainTokens     []string        `json:"chainTokens"`
+	AvailableTokens []string        `json:"availableTokens"`
+	Holder          CosmjsAccount   `json:"holder"`
+	Distributors    []CosmjsAccount `json:"distributors"`
+}
+
+type CosmjsAccount struct {
+	Address string            `json:"address"`
+	Balance cosmostypes.Coins `json:"balance"`
+}
+
+// handleCosmjs registers the @cosmjs/faucet routes
+func (fh FaucetHandler) handleCosmjs() {
+	http.HandleFunc("/credit", fh.cosmjsCredit(""))
+	http.HandleFunc("/status", fh.cosmjsStatus(""))
+	for _, c := range fh.chains {
+		http.HandleFunc("/"+c.Prefix+"/credit", fh.cosmjsCredit(c.Prefix))
+		http.HandleFunc("/"+c.Prefix+"/status", fh.cosmjsStatus(c.Prefix))
+	}
+}
+
+// cosmjsCredit handles POST /credit, which replies in plain text
+func (fh FaucetHandler) cosmjsCredit(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, r.Method+" is not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body CosmjsCreditBody
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+			return
+		}
+		address := strings.TrimSpace(body.Address)
+		if address == "" {
+			http.Error(w, "Address must be a non-empty string", http.StatusBadRequest)
+			return
+		}
+		if prefix != "" {
+			addrPrefix, _, err := bech32.Decode(address, 1023)
+			if err == nil && addrPrefix != prefix {
+				http.Error(w, fmt.Sprintf("%s is not a %s address", address, prefix), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ip := clientIP(r)
+		log.Infof("cosmjs request from %s", ip)
+		_, err = fh.dispense(DispenseRequest{
+			Address:   address,
+			Denom:     strings.TrimSpace(body.Denom),
+			Frontend:  db.FrontendHTTP,
+			Requester: ip,
+			ClientIP:  ip,
+		})
+		if err != nil {
+			writeCosmjsError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "ok")
+	}
+}
+
+// cosmjsStatus handles GET /status
+func (fh FaucetHandler) cosmjsStatus(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, r.Method+" is not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		chainPrefix := prefix
+		if chainPrefix == "" {
+			chainPrefix = r.URL.Query().Get("prefix")
+		}
+		if chainPrefix == "" && len(fh.chains) == 1 {
+			chainPrefix = fh.chains[0].Prefix
+		}
+		c := fh.chains.FindByPrefix(chainPrefix)
+		if c == nil {
+			http.Error(w, "prefix must be one of "+strings.Join(chainPrefixes(fh.chains), ", "), http.StatusBadRequest)
+			return
+		}
+		if !c.Available() {
+			http.Error(w, c.Prefix+" is currently unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		status, err := cosmjsChainStatus(r, c)
+		if err != nil {
+			log.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJson(w, http.StatusOK, status)
+	}
+}
+
+func cosmjsChainStatus(r *http.Request, c *chain.Chain) (CosmjsStatus, error) {
+	address, err := c.FaucetAddress()
+	if err != nil {
+		return CosmjsStatus{}, err
+	}
+	balance, err := c.Balance(r.Context(), address)
+	if err != nil {
+		return CosmjsStatus{}, fmt.Errorf("querying %s balance: %w", c.Prefix, err)
+	}
+	coins, err := cosmostypes.ParseCoinsNormalized(funding[c.Prefix].Coins)
+	if err != nil {
+		return CosmjsStatus{}, err
+	}
+	status := CosmjsStatus{
+		Status:          "ok",
+		NodeURL:         c.RPC,
+		ChainID:         c.ChainID(),
+		ChainTokens:     []string{},
+		AvailableTokens: []string{},
+		Holder:          CosmjsAccount{address, append(cosmostypes.Coins{}, balance...)},
+		Distributors:    []CosmjsAccount{},
+	}
+	for _, signer := range c.Signers()[1:] {
+		balance, err := c.Balance(r.Context(), signer.Address)
+		if err != nil {
+			return CosmjsStatus{}, fmt.Errorf("querying %s balance: %w", c.Prefix, err)
+		}
+		status.Distributors = append(status.Distributors, CosmjsAccount{signer.Address, append(cosmostypes.Coins{}, balance...)})
+	}
+	for _, coin := range coins {
+		status.ChainTokens = append(status.ChainTokens, coin.Denom)
+		// a token is available while the holder can afford one more request
+		if balance.AmountOf(coin.Denom).GTE(coin.Amount) {
+			status.AvailableTokens = append(status.AvailableTokens, coin.Denom)
+		}
+	}
+	return status, nil
+}
+
+func writeCosmjsError(w http.ResponseWriter, err error) {
+	code := errorCode(err)
+	setRetryAfter(w, err)
+	if code == ErrInternal {
+		log.Error(err)
+	}
+	http.Error(w, err.Error(), errorStatus[code])
+}
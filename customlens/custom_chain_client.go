@@ -2,25 +2,70 @@ package customlens
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/cosmos/cosmos-sdk/client/tx"
+	"github.com/cosmos/cosmos-sdk/crypto/hd"
 	sdk "github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
 	lens "github.com/strangelove-ventures/lens/client"
+	tmtypes "github.com/tendermint/tendermint/types"
 )
 
 type CustomChainClient struct {
 	*lens.ChainClient
+	sequence *sequenceManager
 }
 
+func NewCustomChainClient(c *lens.ChainClient) *CustomChainClient {
+	return &CustomChainClient{ChainClient: c, sequence: &sequenceManager{}}
+}
+
+// SignedTx is a tx that is about to be broadcast
+type SignedTx struct {
+	// Hash is the hex encoded hash of Bytes
+	Hash     string
+	Bytes    []byte
+	Sequence uint64
+}
+
+// SignedFunc is called with every signed tx before it is broadcast, the tx
+// is not broadcast when it returns an error
+type SignedFunc func(signed SignedTx) error
+
 // SendMsg yeet
-func (cc *CustomChainClient) SendMsg(ctx context.Context, msg sdk.Msg, fees string) (*sdk.TxResponse, error) {
-	return cc.SendMsgs(ctx, []sdk.Msg{msg}, fees)
+func (cc *CustomChainClient) SendMsg(ctx context.Context, msg sdk.Msg, fees string, onSigned SignedFunc) (*sdk.TxResponse, error) {
+	return cc.SendMsgs(ctx, []sdk.Msg{msg}, fees, onSigned)
+}
+
+// EstimateGas simulates msgs and returns the adjusted gas SendMsgs would use.
+// The simulation runs on top of the txs in the mempool, so it needs the
+// cached sequence while batches are pipelined.
+func (cc *CustomChainClient) EstimateGas(msgs []sdk.Msg) (uint64, error) {
+	txf, err := cc.prepareFactory()
+	if err != nil {
+		return 0, err
+	}
+	_, adjusted, err := cc.ChainClient.CalculateGas(txf, msgs...)
+	if err != nil {
+		return 0, err
+	}
+	return adjusted, nil
 }
 
-// SendMsgs yeet
-func (cc *CustomChainClient) SendMsgs(ctx context.Context, msgs []sdk.Msg, fees string) (*sdk.TxResponse, error) {
-	txf, err := cc.PrepareFactory(cc.TxFactory())
+// SendMsgs signs and broadcasts msgs. It returns once the tx passed
+// CheckTx, use WaitForTx to confirm it was committed. onSigned is optional.
+func (cc *CustomChainClient) SendMsgs(ctx context.Context, msgs []sdk.Msg, fees string, onSigned SignedFunc) (*sdk.TxResponse, error) {
+	// txs are signed and broadcast one at a time, so each gets the next
+	// sequence without waiting for the previous one to be committed
+	cc.sequence.sending.Lock()
+	defer cc.sequence.sending.Unlock()
+
+	txf, err := cc.prepareFactory()
 	if err != nil {
 		return nil, err
 	}
@@ -30,6 +75,7 @@ func (cc *CustomChainClient) SendMsgs(ctx context.Context, msgs []sdk.Msg, fees
 	// https://github.com/cosmos/cosmos-sdk/blob/5725659684fc93790a63981c653feee33ecf3225/client/tx/tx.go#L297
 	_, adjusted, err := cc.ChainClient.CalculateGas(txf, msgs...)
 	if err != nil {
+		cc.sequence.resyncOnMismatch(err)
 		return nil, err
 	}
 
@@ -74,18 +120,178 @@ func (cc *CustomChainClient) SendMsgs(ctx context.Context, msgs []sdk.Msg, fees
 		return nil, err
 	}
 
+	if onSigned != nil {
+		err = onSigned(SignedTx{
+			Hash:     fmt.Sprintf("%X", tmtypes.Tx(txBytes).Hash()),
+			Bytes:    txBytes,
+			Sequence: txf.Sequence(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Broadcast those bytes
-	res, err := cc.BroadcastTx(ctx, txBytes)
+	res, err := cc.broadcastTxSync(ctx, txBytes)
 	if err != nil {
-		return nil, err
+		// the tx may or may not be in the mempool, so the same bytes are
+		// broadcast again instead of signing the msgs again
+		res = cc.rebroadcastTx(ctx, txBytes)
 	}
 
 	// transaction was executed, log the success or failure using the tx response code
 	// NOTE: error is nil, logic should use the returned error to determine if the
 	// transaction was successfully executed.
 	if res.Code != 0 {
+		if res.Codespace == sdkerrors.RootCodespace && res.Code == sdkerrors.ErrWrongSequence.ABCICode() {
+			cc.sequence.resync()
+		}
 		return res, fmt.Errorf("transaction failed with code: %d", res.Code)
 	}
 
+	cc.sequence.next(txf)
 	return res, nil
 }
+
+// prepareFactory returns a factory with the cached account number and
+// sequence. PrepareFactory is only used to query them when they are not
+// synced, it queries them again whenever either is 0, e.g. for a genesis
+// account with account number 0.
+func (cc *CustomChainClient) prepareFactory() (tx.Factory, error) {
+	if txf, ok := cc.sequence.apply(cc.TxFactory()); ok {
+		return txf, nil
+	}
+	return cc.PrepareFactory(cc.TxFactory())
+}
+
+// broadcastTxSync broadcasts a tx and returns the result of CheckTx
+func (cc *CustomChainClient) broadcastTxSync(ctx context.Context, txBytes []byte) (*sdk.TxResponse, error) {
+	syncRes, err := cc.RPCClient.BroadcastTxSync(ctx, txBytes)
+	if errRes := lens.CheckTendermintError(err, txBytes); errRes != nil {
+		return errRes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sdk.NewResponseFormatBroadcastTx(syncRes), nil
+}
+
+const (
+	rebroadcastAttempts = 5
+	rebroadcastDelay    = time.Second * 2
+)
+
+// rebroadcastTx broadcasts a tx whose broadcast failed with an ambiguous
+// error, e.g. a timeout, again until the node tells whether it has the tx.
+// When that stays unknown it returns a response with only the tx hash, so
+// the caller waits for the tx instead of sending its msgs a second time.
+func (cc *CustomChainClient) rebroadcastTx(ctx context.Context, txBytes []byte) *sdk.TxResponse {
+	tx := tmtypes.Tx(txBytes)
+	pending := &sdk.TxResponse{TxHash: fmt.Sprintf("%X", tx.Hash())}
+	delay := rebroadcastDelay
+	for attempt := 1; attempt <= rebroadcastAttempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return pending
+		case <-time.After(delay):
+		}
+		delay *= 2
+
+		if resTx, err := cc.RPCClient.Tx(ctx, tx.Hash(), false); err == nil {
+			// the first broadcast made it into a block
+			return sdk.NewResponseResultTx(resTx, nil, "")
+		}
+		res, err := cc.broadcastTxSync(ctx, txBytes)
+		if err != nil {
+			continue
+		}
+		if res.Codespace == sdkerrors.RootCodespace {
+			switch res.Code {
+			case sdkerrors.ErrMempoolIsFull.ABCICode():
+				// the node checks whether the mempool is full before it
+				// looks for the tx, this says nothing about the first broadcast
+				continue
+			case sdkerrors.ErrTxInMempoolCache.ABCICode():
+				// the first broadcast reached the mempool
+				return pending
+			case sdkerrors.ErrWrongSequence.ABCICode():
+				// the sequence is used, only this tx can have used it
+				return pending
+			}
+		}
+		// passed CheckTx now, or was rejected and is not in the mempool
+		return res
+	}
+	return pending
+}
+
+// BroadcastTx broadcasts signed tx bytes again, e.g. when the node dropped
+// the tx from its mempool. It returns the result of CheckTx.
+func (cc *CustomChainClient) BroadcastTx(ctx context.Context, txBytes []byte) (*sdk.TxResponse, error) {
+	return cc.broadcastTxSync(ctx, txBytes)
+}
+
+// ErrTxNotFound is returned by QueryTx for txs that are not committed, or
+// not indexed yet
+var ErrTxNotFound = errors.New("tx not found")
+
+// QueryTx returns the committed tx with the hex encoded hash
+func (cc *CustomChainClient) QueryTx(ctx context.Context, hash string) (*sdk.TxResponse, error) {
+	rawHash, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, err
+	}
+	resTx, err := cc.RPCClient.Tx(ctx, rawHash, false)
+	if err != nil {
+		// the node only tells with the message of its error
+		if strings.Contains(err.Error(), "not found") {
+			return nil, fmt.Errorf("%w: %s", ErrTxNotFound, hash)
+		}
+		return nil, err
+	}
+	return sdk.NewResponseResultTx(resTx, nil, ""), nil
+}
+
+const txPollInterval = time.Second
+
+// WaitForTx polls the RPC node until the tx with the hex encoded hash is
+// committed in a block, or ctx is done. It fails right away on errors that
+// polling does not fix, e.g. when the node does not index txs.
+func (cc *CustomChainClient) WaitForTx(ctx context.Context, hash string) (*sdk.TxResponse, error) {
+	t := time.NewTicker(txPollInterval)
+	defer t.Stop()
+	for {
+		res, err := cc.QueryTx(ctx, hash)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !errors.Is(err, ErrTxNotFound) {
+			return res, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// AccountSequence returns the sequence the next tx of the account must be
+// signed with, as of the latest block
+func (cc *CustomChainClient) AccountSequence(address sdk.AccAddress) (uint64, error) {
+	account, err := cc.QueryAccount(address)
+	if err != nil {
+		return 0, err
+	}
+	return account.GetSequence(), nil
+}
+
+// RestoreKey restores the key at the HD address index of the mnemonic and
+// returns its bech32 address
+func (cc *CustomChainClient) RestoreKey(keyName string, coinType uint32, index uint32, mnemonic string) (string, error) {
+	info, err := cc.Keybase.NewAccount(keyName, mnemonic, "", hd.CreateHDPath(coinType, 0, index).String(), hd.Secp256k1)
+	if err != nil {
+		return "", err
+	}
+	return cc.EncodeBech32AccAddr(info.GetAddress())
+}
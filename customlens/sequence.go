@@ -0,0 +1,55 @@
+package customlens
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/cosmos/cosmos-sdk/client/tx"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+// sequenceManager caches the account number and sequence of the signing
+// key, so a tx can be signed while the previous one is still in the mempool
+type sequenceManager struct {
+	// sending is held while a tx is signed and broadcast
+	sending sync.Mutex
+
+	mu            sync.Mutex
+	accountNumber uint64
+	sequence      uint64
+	synced        bool
+}
+
+// apply sets the cached account number and sequence on the factory, it
+// returns false when they must be queried from the chain
+func (m *sequenceManager) apply(txf tx.Factory) (tx.Factory, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.synced {
+		return txf, false
+	}
+	return txf.WithAccountNumber(m.accountNumber).WithSequence(m.sequence), true
+}
+
+// next records that the tx signed by the factory passed CheckTx
+func (m *sequenceManager) next(txf tx.Factory) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accountNumber = txf.AccountNumber()
+	m.sequence = txf.Sequence() + 1
+	m.synced = true
+}
+
+// resync makes the next tx query the sequence from the chain again
+func (m *sequenceManager) resync() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.synced = false
+}
+
+func (m *sequenceManager) resyncOnMismatch(err error) {
+	if errors.Is(err, sdkerrors.ErrWrongSequence) || strings.Contains(err.Error(), "account sequence mismatch") {
+		m.resync()
+	}
+}
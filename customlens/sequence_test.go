@@ -0,0 +1,29 @@
+package customlens
+
+import (
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/client/tx"
+)
+
+func TestSequenceManagerGenesisAccount(t *testing.T) {
+	var m sequenceManager
+	if _, ok := m.apply(tx.Factory{}); ok {
+		t.Fatal("apply() before the first tx is synced")
+	}
+
+	// genesis accounts have account number 0
+	m.next(tx.Factory{}.WithAccountNumber(0).WithSequence(0))
+	txf, ok := m.apply(tx.Factory{})
+	if !ok {
+		t.Fatal("apply() after a tx passed CheckTx is not synced")
+	}
+	if txf.AccountNumber() != 0 || txf.Sequence() != 1 {
+		t.Errorf("apply() = account %d, sequence %d, want account 0, sequence 1", txf.AccountNumber(), txf.Sequence())
+	}
+
+	m.resync()
+	if _, ok := m.apply(tx.Factory{}); ok {
+		t.Error("apply() after resync is synced")
+	}
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ledgerBucket       = []byte("funding-ledger")
+	reservationsBucket = []byte("funding-reservations")
+)
+
+// BoltDb is a Store implementation backed by an embedded BoltDB file, so the
+// faucet can run on-prem without any external database.
+type BoltDb struct {
+	bolt *bolt.DB
+}
+
+func NewBoltDb(path string) (*BoltDb, error) {
+	if path == "" {
+		path = "fonzie.db"
+	}
+	client, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = client.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{ledgerBucket, reservationsBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &BoltDb{
+		bolt: client,
+	}, nil
+}
+
+func (db *BoltDb) AppendFundingReceipt(ctx context.Context, newReceipt FundingReceipt) (string, error) {
+	if newReceipt.ID == "" {
+		newReceipt.ID = newReceiptID()
+	}
+	value, err := json.Marshal(newReceipt)
+	if err != nil {
+		return "", err
+	}
+	err = db.bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).Put([]byte(newReceipt.ID), value)
+	})
+	if err != nil {
+		return "", err
+	}
+	return newReceipt.ID, nil
+}
+
+func (db *BoltDb) UpdateFundingReceipt(ctx context.Context, id string, update ReceiptUpdate) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		table := tx.Bucket(ledgerBucket)
+		value := table.Get([]byte(id))
+		if value == nil {
+			return ErrReceiptNotFound
+		}
+		var receipt FundingReceipt
+		err := json.Unmarshal(value, &receipt)
+		if err != nil {
+			return err
+		}
+		if !update.allowed(receipt) {
+			return ErrReceiptClaimed
+		}
+		update.apply(&receipt)
+		value, err = json.Marshal(receipt)
+		if err != nil {
+			return err
+		}
+		return table.Put([]byte(id), value)
+	})
+}
+
+func (db *BoltDb) ClaimFundingReceipt(ctx context.Context, id string, claim ReceiptClaim) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		table := tx.Bucket(ledgerBucket)
+		value := table.Get([]byte(id))
+		if value == nil {
+			return ErrReceiptNotFound
+		}
+		var receipt FundingReceipt
+		err := json.Unmarshal(value, &receipt)
+		if err != nil {
+			return err
+		}
+		if !claim.allowed(receipt) {
+			return ErrReceiptClaimed
+		}
+		claim.apply(&receipt)
+		value, err = json.Marshal(receipt)
+		if err != nil {
+			return err
+		}
+		return table.Put([]byte(id), value)
+	})
+}
+
+func (db *BoltDb) GetFundingReceipt(ctx context.Context, id string) (*FundingReceipt, error) {
+	var out *FundingReceipt
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(ledgerBucket).Get([]byte(id))
+		if value == nil {
+			return ErrReceiptNotFound
+		}
+		out = &FundingReceipt{}
+		return json.Unmarshal(value, out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (db *BoltDb) ListFundingReceipts(ctx context.Context, query ReceiptQuery) (FundingReceipts, error) {
+	var out FundingReceipts
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).ForEach(func(key, value []byte) error {
+			var receipt FundingReceipt
+			err := json.Unmarshal(value, &receipt)
+			if err != nil {
+				return err
+			}
+			if query.matches(receipt) {
+				out = append(out, receipt)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (db *BoltDb) PruneExpiredReceipts(ctx context.Context, cutoffs map[ChainPrefix]time.Time) (int, error) {
+	var numPruned int
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		table := tx.Bucket(ledgerBucket)
+		// collect first, deleting while iterating makes the cursor skip keys
+		var expired [][]byte
+		err := table.ForEach(func(key, value []byte) error {
+			var receipt FundingReceipt
+			err := json.Unmarshal(value, &receipt)
+			if err != nil {
+				return err
+			}
+			if isExpired(receipt, cutoffs) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			err = table.Delete(key)
+			if err != nil {
+				return err
+			}
+			numPruned += 1
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return numPruned, nil
+}
+
+func (db *BoltDb) Reserve(ctx context.Context, now time.Time, reservations ...Reservation) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		table := tx.Bucket(reservationsBucket)
+		for _, r := range reservations {
+			value := table.Get([]byte(r.Key))
+			if value == nil {
+				continue
+			}
+			var existing Reservation
+			err := json.Unmarshal(value, &existing)
+			if err != nil {
+				return err
+			}
+			if existing.ExpiresAt.After(now) {
+				return &ReservedError{Key: r.Key, Until: existing.ExpiresAt}
+			}
+		}
+		for _, r := range reservations {
+			value, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			err = table.Put([]byte(r.Key), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *BoltDb) GetReservations(ctx context.Context, keys ...string) ([]Reservation, error) {
+	var out []Reservation
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		table := tx.Bucket(reservationsBucket)
+		for _, key := range keys {
+			value := table.Get([]byte(key))
+			if value == nil {
+				continue
+			}
+			var r Reservation
+			err := json.Unmarshal(value, &r)
+			if err != nil {
+				return err
+			}
+			out = append(out, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (db *BoltDb) ReleaseReservations(ctx context.Context, keys ...string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		table := tx.Bucket(reservationsBucket)
+		for _, key := range keys {
+			err := table.Delete([]byte(key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *BoltDb) PruneExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	var numPruned int
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		table := tx.Bucket(reservationsBucket)
+		var expired [][]byte
+		err := table.ForEach(func(key, value []byte) error {
+			var r Reservation
+			err := json.Unmarshal(value, &r)
+			if err != nil {
+				return err
+			}
+			if !r.ExpiresAt.After(now) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			err = table.Delete(key)
+			if err != nil {
+				return err
+			}
+			numPruned += 1
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return numPruned, nil
+}
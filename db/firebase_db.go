@@ -2,16 +2,13 @@ package db
 
 import (
 	"context"
-	"crypto/md5"
 	"os"
 	"time"
 
 	b64 "encoding/base64"
-	"encoding/hex"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
-	cosmostypes "github.com/cosmos/cosmos-sdk/types"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
@@ -19,30 +16,20 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-type ChainPrefix = string
-type Username = string
-type FundingReceipt struct {
-	ChainPrefix ChainPrefix       `firestore:"chainPrefix"`
-	Username    Username          `firestore:"username"`
-	FundedAt    time.Time         `firestore:"fundedAt"`
-	Amount      cosmostypes.Coins `firestore:"amount"`
-}
-type FundingReceipts []FundingReceipt
-
-// Db represents the application interface for accessing the database
-type Db struct {
+// FirestoreDb is the Store implementation backed by Google Cloud Firestore
+type FirestoreDb struct {
 	firestore *firestore.Client
 }
 
-func NewDb(ctx context.Context) Db {
+func NewFirestoreDb(ctx context.Context) (*FirestoreDb, error) {
 	// Initialize Firestore
 	client, err := initFirestore(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return Db{
+	return &FirestoreDb{
 		firestore: client,
-	}
+	}, nil
 }
 
 // ProvideFirestore returns a *firestore.Client
@@ -58,17 +45,17 @@ func initFirestore(ctx context.Context) (*firestore.Client, error) {
 		log.Info("Importing GCP credentials from env")
 		json, err = b64.StdEncoding.DecodeString(os.Getenv("GCP_CREDENTIALS"))
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		app, err = firebase.NewApp(ctx, conf, option.WithCredentialsJSON([]byte(json)))
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 	} else {
 		// local dev/application-default case
 		app, err = firebase.NewApp(ctx, conf)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 	}
 
@@ -85,83 +72,333 @@ func initFirestore(ctx context.Context) (*firestore.Client, error) {
 	return client, nil
 }
 
-func (db Db) SaveFundingReceipt(ctx context.Context, newReceipt FundingReceipt) error {
-	if os.Getenv("DEBUG") != "" {
-		// don't accumulate receipts in debug mode
-		return nil
+const (
+	ledgerCollection       = "funding-ledger"
+	reservationsCollection = "funding-reservations"
+	// legacyReceiptsCollection is where receipts were kept before the
+	// funding ledger
+	legacyReceiptsCollection = "funding-receipts"
+)
+
+func (db *FirestoreDb) LegacyFundingReceipts(ctx context.Context) ([]LegacyFundingReceipt, error) {
+	iter := db.firestore.Collection(legacyReceiptsCollection).Documents(ctx)
+	defer iter.Stop()
+
+	var out []LegacyFundingReceipt
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var receipt LegacyFundingReceipt
+		err = doc.DataTo(&receipt)
+		if err != nil {
+			return nil, err
+		}
+		receipt.ID = doc.Ref.ID
+		out = append(out, receipt)
 	}
-	table := db.firestore.Collection("funding-receipts")
-	ref := table.Doc(mkPKEY(newReceipt.Username, newReceipt.ChainPrefix))
 
-	_, err := ref.Set(ctx, newReceipt)
-	return err
+	return out, nil
+}
+
+func (db *FirestoreDb) DeleteLegacyFundingReceipts(ctx context.Context, ids ...string) error {
+	table := db.firestore.Collection(legacyReceiptsCollection)
+
+	// firestore batches hold up to 500 writes
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > 500 {
+			n = 500
+		}
+		batch := db.firestore.Batch()
+		for _, id := range ids[:n] {
+			batch.Delete(table.Doc(id))
+		}
+		_, err := batch.Commit(ctx)
+		if err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+func (db *FirestoreDb) AppendFundingReceipt(ctx context.Context, newReceipt FundingReceipt) (string, error) {
+	if newReceipt.ID == "" {
+		newReceipt.ID = newReceiptID()
+	}
+	table := db.firestore.Collection(ledgerCollection)
+
+	_, err := table.Doc(newReceipt.ID).Create(ctx, newReceipt)
+	if err != nil {
+		return "", err
+	}
+	return newReceipt.ID, nil
+}
+
+func (db *FirestoreDb) UpdateFundingReceipt(ctx context.Context, id string, update ReceiptUpdate) error {
+	doc := db.firestore.Collection(ledgerCollection).Doc(id)
+	updates := []firestore.Update{
+		{Path: "status", Value: update.Status},
+		{Path: "txHash", Value: update.TxHash},
+		{Path: "signer", Value: update.Signer},
+		{Path: "sequence", Value: update.Sequence},
+		{Path: "txBytes", Value: update.TxBytes},
+		{Path: "height", Value: update.Height},
+		{Path: "error", Value: update.Error},
+	}
+
+	if update.Owner == "" {
+		_, err := doc.Update(ctx, updates)
+		if status.Code(err) == codes.NotFound {
+			return ErrReceiptNotFound
+		}
+		return err
+	}
+	return db.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		receipt, err := getReceipt(tx, doc)
+		if err != nil {
+			return err
+		}
+		if !update.allowed(*receipt) {
+			return ErrReceiptClaimed
+		}
+		return tx.Update(doc, updates)
+	})
+}
+
+func (db *FirestoreDb) ClaimFundingReceipt(ctx context.Context, id string, claim ReceiptClaim) error {
+	doc := db.firestore.Collection(ledgerCollection).Doc(id)
+
+	return db.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		receipt, err := getReceipt(tx, doc)
+		if err != nil {
+			return err
+		}
+		if !claim.allowed(*receipt) {
+			return ErrReceiptClaimed
+		}
+		return tx.Update(doc, []firestore.Update{
+			{Path: "owner", Value: claim.Owner},
+			{Path: "claimedAt", Value: claim.ClaimedAt},
+		})
+	})
+}
+
+// getReceipt reads a receipt in a transaction
+func getReceipt(tx *firestore.Transaction, doc *firestore.DocumentRef) (*FundingReceipt, error) {
+	snapshot, err := tx.Get(doc)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrReceiptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var receipt FundingReceipt
+	err = snapshot.DataTo(&receipt)
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
 }
 
-func (db Db) PruneExpiredReceipts(ctx context.Context, beforeFundingTime time.Time) (int, error) {
-	table := db.firestore.Collection("funding-receipts")
+func (db *FirestoreDb) GetFundingReceipt(ctx context.Context, id string) (*FundingReceipt, error) {
+	table := db.firestore.Collection(ledgerCollection)
+
+	doc, err := table.Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrReceiptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out FundingReceipt
+	err = doc.DataTo(&out)
+	if err != nil {
+		return nil, err
+	}
 
-	iter := table.Documents(ctx)
+	return &out, nil
+}
+
+// ListFundingReceipts needs a composite index for each combination of filters
+// with a time bound, they are listed in firestore.indexes.json
+func (db *FirestoreDb) ListFundingReceipts(ctx context.Context, query ReceiptQuery) (FundingReceipts, error) {
+	q := db.firestore.Collection(ledgerCollection).Query
+	if query.ChainPrefix != "" {
+		q = q.Where("chainPrefix", "==", query.ChainPrefix)
+	}
+	if query.Requester != "" {
+		q = q.Where("requester", "==", query.Requester)
+	}
+	if !query.Since.IsZero() {
+		q = q.Where("fundedAt", ">=", query.Since)
+	}
+	if !query.Before.IsZero() {
+		q = q.Where("fundedAt", "<", query.Before)
+	}
+	if len(query.Statuses) > 0 {
+		statuses := make([]string, 0, len(query.Statuses))
+		statuses = append(statuses, query.Statuses...)
+		q = q.Where("status", "in", statuses)
+	}
+
+	iter := q.Documents(ctx)
 	defer iter.Stop()
 
-	var numPruned int
+	var out FundingReceipts
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return numPruned, err
+			return nil, err
 		}
 
 		var receipt FundingReceipt
 		err = doc.DataTo(&receipt)
 		if err != nil {
-			return numPruned, err
+			return nil, err
 		}
+		out = append(out, receipt)
+	}
+
+	return out, nil
+}
+
+func (db *FirestoreDb) PruneExpiredReceipts(ctx context.Context, cutoffs map[ChainPrefix]time.Time) (int, error) {
+	table := db.firestore.Collection(ledgerCollection)
+
+	var numPruned int
+	for prefix, cutoff := range cutoffs {
+		iter := table.Where("chainPrefix", "==", prefix).Where("fundedAt", "<", cutoff).Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return numPruned, err
+			}
 
-		if receipt.FundedAt.Before(beforeFundingTime) {
 			_, err = doc.Ref.Delete(ctx)
 			if err != nil {
+				iter.Stop()
 				return numPruned, err
 			}
 			numPruned += 1
 		}
+		iter.Stop()
 	}
 
 	return numPruned, nil
 }
 
-func (db Db) GetFundingReceiptByUsernameAndChainPrefix(ctx context.Context, username string, chainPrefix string) (*FundingReceipt, error) {
-	if os.Getenv("DEBUG") != "" {
-		// allow unlimited faucet tapping in debug mode
-		return nil, nil
-	}
+func (db *FirestoreDb) Reserve(ctx context.Context, now time.Time, reservations ...Reservation) error {
+	table := db.firestore.Collection(reservationsCollection)
 
-	table := db.firestore.Collection("funding-receipts")
-	ref := table.Doc(mkPKEY(username, chainPrefix))
+	return db.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// firestore transactions need all reads to happen before any write
+		for _, r := range reservations {
+			doc, err := tx.Get(table.Doc(r.Key))
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			var existing Reservation
+			err = doc.DataTo(&existing)
+			if err != nil {
+				return err
+			}
+			if existing.ExpiresAt.After(now) {
+				return &ReservedError{Key: r.Key, Until: existing.ExpiresAt}
+			}
+		}
+		for _, r := range reservations {
+			err := tx.Set(table.Doc(r.Key), r)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
-	doc, err := ref.Get(ctx)
-	if status.Code(err) == codes.NotFound {
+func (db *FirestoreDb) GetReservations(ctx context.Context, keys ...string) ([]Reservation, error) {
+	if len(keys) == 0 {
 		return nil, nil
 	}
+	table := db.firestore.Collection(reservationsCollection)
+
+	refs := make([]*firestore.DocumentRef, 0, len(keys))
+	for _, key := range keys {
+		refs = append(refs, table.Doc(key))
+	}
+	docs, err := db.firestore.GetAll(ctx, refs)
 	if err != nil {
 		return nil, err
 	}
+	var out []Reservation
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		var r Reservation
+		err = doc.DataTo(&r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
 
-	var out FundingReceipt
-	err = doc.DataTo(&out)
-	if err != nil {
-		return nil, err
+func (db *FirestoreDb) ReleaseReservations(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
 	}
+	table := db.firestore.Collection(reservationsCollection)
 
-	return &out, nil
+	batch := db.firestore.Batch()
+	for _, key := range keys {
+		batch.Delete(table.Doc(key))
+	}
+	_, err := batch.Commit(ctx)
+	return err
 }
 
-func mkPKEY(username string, chainPrefix string) string {
-	return getMD5Hash(username + chainPrefix)
-}
+func (db *FirestoreDb) PruneExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	table := db.firestore.Collection(reservationsCollection)
 
-func getMD5Hash(text string) string {
-	hash := md5.Sum([]byte(text))
-	return hex.EncodeToString(hash[:])
+	iter := table.Where("expiresAt", "<=", now).Documents(ctx)
+	defer iter.Stop()
+
+	var numPruned int
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return numPruned, err
+		}
+
+		_, err = doc.Ref.Delete(ctx)
+		if err != nil {
+			return numPruned, err
+		}
+		numPruned += 1
+	}
+
+	return numPruned, nil
 }
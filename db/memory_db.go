@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryDb is a Store implementation that keeps the ledger in process memory.
+// Receipts are lost on restart, which makes it suitable for local devnets.
+type MemoryDb struct {
+	mu           sync.Mutex
+	receipts     FundingReceipts
+	reservations map[string]Reservation
+}
+
+func NewMemoryDb() *MemoryDb {
+	return &MemoryDb{
+		reservations: make(map[string]Reservation),
+	}
+}
+
+func (db *MemoryDb) AppendFundingReceipt(ctx context.Context, newReceipt FundingReceipt) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if newReceipt.ID == "" {
+		newReceipt.ID = newReceiptID()
+	}
+	db.receipts = append(db.receipts, newReceipt)
+	return newReceipt.ID, nil
+}
+
+func (db *MemoryDb) UpdateFundingReceipt(ctx context.Context, id string, update ReceiptUpdate) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for i := range db.receipts {
+		if db.receipts[i].ID == id {
+			if !update.allowed(db.receipts[i]) {
+				return ErrReceiptClaimed
+			}
+			update.apply(&db.receipts[i])
+			return nil
+		}
+	}
+	return ErrReceiptNotFound
+}
+
+func (db *MemoryDb) ClaimFundingReceipt(ctx context.Context, id string, claim ReceiptClaim) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for i := range db.receipts {
+		if db.receipts[i].ID == id {
+			if !claim.allowed(db.receipts[i]) {
+				return ErrReceiptClaimed
+			}
+			claim.apply(&db.receipts[i])
+			return nil
+		}
+	}
+	return ErrReceiptNotFound
+}
+
+func (db *MemoryDb) GetFundingReceipt(ctx context.Context, id string) (*FundingReceipt, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, receipt := range db.receipts {
+		if receipt.ID == id {
+			return &receipt, nil
+		}
+	}
+	return nil, ErrReceiptNotFound
+}
+
+func (db *MemoryDb) ListFundingReceipts(ctx context.Context, query ReceiptQuery) (FundingReceipts, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var out FundingReceipts
+	for _, receipt := range db.receipts {
+		if query.matches(receipt) {
+			out = append(out, receipt)
+		}
+	}
+	return out, nil
+}
+
+func (db *MemoryDb) PruneExpiredReceipts(ctx context.Context, cutoffs map[ChainPrefix]time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	kept := db.receipts[:0]
+	for _, receipt := range db.receipts {
+		if !isExpired(receipt, cutoffs) {
+			kept = append(kept, receipt)
+		}
+	}
+	numPruned := len(db.receipts) - len(kept)
+	db.receipts = kept
+	return numPruned, nil
+}
+
+func (db *MemoryDb) Reserve(ctx context.Context, now time.Time, reservations ...Reservation) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, r := range reservations {
+		existing, ok := db.reservations[r.Key]
+		if ok && existing.ExpiresAt.After(now) {
+			return &ReservedError{Key: r.Key, Until: existing.ExpiresAt}
+		}
+	}
+	for _, r := range reservations {
+		db.reservations[r.Key] = r
+	}
+	return nil
+}
+
+func (db *MemoryDb) GetReservations(ctx context.Context, keys ...string) ([]Reservation, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var out []Reservation
+	for _, key := range keys {
+		if r, ok := db.reservations[key]; ok {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (db *MemoryDb) ReleaseReservations(ctx context.Context, keys ...string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, key := range keys {
+		delete(db.reservations, key)
+	}
+	return nil
+}
+
+func (db *MemoryDb) PruneExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var numPruned int
+	for key, r := range db.reservations {
+		if !r.ExpiresAt.After(now) {
+			delete(db.reservations, key)
+			numPruned += 1
+		}
+	}
+	return numPruned, nil
+}
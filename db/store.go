@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+)
+
+type ChainPrefix = string
+type Username = string
+type Frontend = string
+type ReceiptStatus = string
+
+const (
+	FrontendDiscord Frontend = "discord"
+	FrontendHTTP    Frontend = "http"
+
+	// ReceiptQueued is set while the request waits in a worker batch
+	ReceiptQueued ReceiptStatus = "queued"
+	// ReceiptBroadcast is set once the batch transaction is being broadcast
+	ReceiptBroadcast ReceiptStatus = "broadcast"
+	// ReceiptIncluded is set once the batch transaction was included in a block
+	ReceiptIncluded ReceiptStatus = "included"
+	// ReceiptFailed is set when the batch transaction could not be executed
+	ReceiptFailed ReceiptStatus = "failed"
+)
+
+// FundingReceipt is one entry of the append-only funding ledger, there is
+// one receipt for every dispense.
+type FundingReceipt struct {
+	ID          string            `firestore:"id" json:"id"`
+	ChainPrefix ChainPrefix       `firestore:"chainPrefix" json:"chainPrefix"`
+	Recipient   string            `firestore:"recipient" json:"recipient"`
+	Requester   Username          `firestore:"requester" json:"requester"`
+	Frontend    Frontend          `firestore:"frontend" json:"frontend"`
+	FundedAt    time.Time         `firestore:"fundedAt" json:"fundedAt"`
+	Amount      cosmostypes.Coins `firestore:"amount" json:"amount"`
+	Fees        cosmostypes.Coins `firestore:"fees" json:"fees"`
+	Tier        string            `firestore:"tier" json:"tier,omitempty"`
+	TxHash      string            `firestore:"txHash" json:"txHash"`
+	Height      int64             `firestore:"height" json:"height"`
+	Status      ReceiptStatus     `firestore:"status" json:"status"`
+	Error       string            `firestore:"error" json:"error,omitempty"`
+	// Signer, Sequence and TxBytes describe the broadcast tx, so it can be
+	// broadcast again after a restart instead of signing a new one
+	Signer   string `firestore:"signer" json:"signer,omitempty"`
+	Sequence int64  `firestore:"sequence" json:"sequence,omitempty"`
+	TxBytes  []byte `firestore:"txBytes" json:"txBytes,omitempty"`
+	// Reservations are the rate limit keys held for the dispense, released
+	// when it fails
+	Reservations []string `firestore:"reservations" json:"reservations,omitempty"`
+	// Owner is the faucet process sending the dispense since ClaimedAt,
+	// another process replays it only after claiming it with
+	// ClaimFundingReceipt
+	Owner     string    `firestore:"owner" json:"owner,omitempty"`
+	ClaimedAt time.Time `firestore:"claimedAt" json:"claimedAt"`
+}
+type FundingReceipts []FundingReceipt
+
+// TotalAmount sums the dispensed coins of all receipts
+func (receipts FundingReceipts) TotalAmount() cosmostypes.Coins {
+	total := cosmostypes.NewCoins()
+	for _, r := range receipts {
+		total = total.Add(r.Amount...)
+	}
+	return total
+}
+
+// TotalFees sums the fee shares of all receipts
+func (receipts FundingReceipts) TotalFees() cosmostypes.Coins {
+	total := cosmostypes.NewCoins()
+	for _, r := range receipts {
+		total = total.Add(r.Fees...)
+	}
+	return total
+}
+
+// LatestFundedAt returns the most recent funding time, or the zero time when
+// there are no receipts
+func (receipts FundingReceipts) LatestFundedAt() time.Time {
+	var latest time.Time
+	for _, r := range receipts {
+		if r.FundedAt.After(latest) {
+			latest = r.FundedAt
+		}
+	}
+	return latest
+}
+
+// ReceiptQuery filters the funding ledger, zero values match everything
+type ReceiptQuery struct {
+	ChainPrefix ChainPrefix
+	Requester   Username
+	Since       time.Time
+	// Before excludes the receipts funded at or after it
+	Before   time.Time
+	Statuses []ReceiptStatus
+}
+
+func (q ReceiptQuery) matches(r FundingReceipt) bool {
+	if q.ChainPrefix != "" && r.ChainPrefix != q.ChainPrefix {
+		return false
+	}
+	if q.Requester != "" && r.Requester != q.Requester {
+		return false
+	}
+	if !q.Since.IsZero() && r.FundedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Before.IsZero() && !r.FundedAt.Before(q.Before) {
+		return false
+	}
+	if len(q.Statuses) > 0 {
+		for _, status := range q.Statuses {
+			if r.Status == status {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isExpired(r FundingReceipt, cutoffs map[ChainPrefix]time.Time) bool {
+	cutoff, ok := cutoffs[r.ChainPrefix]
+	return ok && r.FundedAt.Before(cutoff)
+}
+
+// ReceiptUpdate holds the outcome of a dispense once its batch was processed
+type ReceiptUpdate struct {
+	Status   ReceiptStatus
+	TxHash   string
+	Signer   string
+	Sequence int64
+	TxBytes  []byte
+	Height   int64
+	Error    string
+	// Owner makes the update conditional, it fails with ErrReceiptClaimed
+	// when the receipt is not held by Owner anymore
+	Owner string
+}
+
+// allowed reports whether the update may be applied to the receipt
+func (u ReceiptUpdate) allowed(r FundingReceipt) bool {
+	return u.Owner == "" || r.Owner == u.Owner
+}
+
+func (u ReceiptUpdate) apply(r *FundingReceipt) {
+	r.Status = u.Status
+	r.TxHash = u.TxHash
+	r.Signer = u.Signer
+	r.Sequence = u.Sequence
+	r.TxBytes = u.TxBytes
+	r.Height = u.Height
+	r.Error = u.Error
+}
+
+// ReceiptClaim hands a receipt over to another faucet process
+type ReceiptClaim struct {
+	// Status and PreviousOwner must still match the receipt
+	Status        ReceiptStatus
+	PreviousOwner string
+	Owner         string
+	// ClaimedAt is when Owner took the receipt over, a release sets no
+	// Owner and the zero time
+	ClaimedAt time.Time
+}
+
+func (c ReceiptClaim) allowed(r FundingReceipt) bool {
+	return r.Status == c.Status && r.Owner == c.PreviousOwner
+}
+
+func (c ReceiptClaim) apply(r *FundingReceipt) {
+	r.Owner = c.Owner
+	r.ClaimedAt = c.ClaimedAt
+}
+
+var (
+	ErrReceiptNotFound = errors.New("funding receipt not found")
+	ErrReceiptClaimed  = errors.New("funding receipt was claimed by another faucet process")
+)
+
+// Reservation blocks further funding for a rate limit key until it expires
+type Reservation struct {
+	Key        string    `firestore:"key" json:"key"`
+	ReservedAt time.Time `firestore:"reservedAt" json:"reservedAt"`
+	ExpiresAt  time.Time `firestore:"expiresAt" json:"expiresAt"`
+}
+
+// ReservedError is returned by Reserve when a key still holds an unexpired
+// reservation
+type ReservedError struct {
+	Key   string
+	Until time.Time
+}
+
+func (e *ReservedError) Error() string {
+	return fmt.Sprintf("%s is reserved until %s", e.Key, e.Until.Format(time.RFC3339))
+}
+
+// ReservationKey derives the key of a reservation from its parts, e.g. the
+// requester and the chain prefix
+func ReservationKey(parts ...string) string {
+	return getMD5Hash(strings.Join(parts, "/"))
+}
+
+// Store represents the application interface for persisting the funding ledger
+type Store interface {
+	// AppendFundingReceipt adds a receipt to the ledger and returns its ID
+	AppendFundingReceipt(ctx context.Context, newReceipt FundingReceipt) (string, error)
+	UpdateFundingReceipt(ctx context.Context, id string, update ReceiptUpdate) error
+	// ClaimFundingReceipt atomically applies the claim, it returns
+	// ErrReceiptClaimed when the receipt changed in the meantime
+	ClaimFundingReceipt(ctx context.Context, id string, claim ReceiptClaim) error
+	// GetFundingReceipt returns ErrReceiptNotFound for unknown IDs
+	GetFundingReceipt(ctx context.Context, id string) (*FundingReceipt, error)
+	ListFundingReceipts(ctx context.Context, query ReceiptQuery) (FundingReceipts, error)
+	// PruneExpiredReceipts removes the receipts of each chain funded before
+	// its cutoff, receipts of chains without a cutoff are kept
+	PruneExpiredReceipts(ctx context.Context, cutoffs map[ChainPrefix]time.Time) (int, error)
+
+	// Reserve atomically stores all reservations, or none of them with a
+	// *ReservedError when one of the keys is still reserved at now
+	Reserve(ctx context.Context, now time.Time, reservations ...Reservation) error
+	// GetReservations returns the stored reservations of the keys, keys
+	// without a reservation are left out
+	GetReservations(ctx context.Context, keys ...string) ([]Reservation, error)
+	ReleaseReservations(ctx context.Context, keys ...string) error
+	PruneExpiredReservations(ctx context.Context, now time.Time) (int, error)
+}
+
+// LegacyFundingReceipt is a receipt from before the funding ledger, there
+// was one per requester and chain holding the time of its last funding
+type LegacyFundingReceipt struct {
+	ID          string            `firestore:"-"`
+	ChainPrefix ChainPrefix       `firestore:"chainPrefix"`
+	Username    Username          `firestore:"username"`
+	FundedAt    time.Time         `firestore:"fundedAt"`
+	Amount      cosmostypes.Coins `firestore:"amount"`
+}
+
+// LegacyStore is implemented by stores that may still hold receipts from
+// before the funding ledger
+type LegacyStore interface {
+	LegacyFundingReceipts(ctx context.Context) ([]LegacyFundingReceipt, error)
+	DeleteLegacyFundingReceipts(ctx context.Context, ids ...string) error
+}
+
+const (
+	StoreFirestore = "firestore"
+	StoreBolt      = "bolt"
+	StoreMemory    = "memory"
+)
+
+// NewStore returns the Store implementation selected by kind; path is only
+// used by file backed stores.
+func NewStore(ctx context.Context, kind string, path string) (Store, error) {
+	switch kind {
+	case "", StoreFirestore:
+		return NewFirestoreDb(ctx)
+	case StoreBolt:
+		return NewBoltDb(path)
+	case StoreMemory:
+		return NewMemoryDb(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q, expected one of %s, %s, %s", kind, StoreFirestore, StoreBolt, StoreMemory)
+	}
+}
+
+func newReceiptID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+func getMD5Hash(text string) string {
+	hash := md5.Sum([]byte(text))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStores creates a fresh store of each kind, firestore needs the emulator
+var testStores = map[string]func(t *testing.T) Store{
+	StoreMemory: func(t *testing.T) Store {
+		return NewMemoryDb()
+	},
+	StoreBolt: func(t *testing.T) Store {
+		store, err := NewBoltDb(filepath.Join(t.TempDir(), "fonzie.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	},
+	StoreFirestore: func(t *testing.T) Store {
+		if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+			t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+		}
+		if os.Getenv("GCP_PROJECT") == "" {
+			t.Setenv("GCP_PROJECT", "fonzie-test")
+		}
+		store, err := NewFirestoreDb(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	},
+}
+
+func TestReserve(t *testing.T) {
+	now := time.Now()
+	reservation := func(key string, expiresAt time.Time) Reservation {
+		return Reservation{Key: key, ReservedAt: now, ExpiresAt: expiresAt}
+	}
+	tests := []struct {
+		name     string
+		existing []Reservation
+		reserve  []Reservation
+		// reserved is the key of the expected *ReservedError
+		reserved string
+	}{
+		{
+			name:    "free keys",
+			reserve: []Reservation{reservation("a", now.Add(time.Hour)), reservation("b", now.Add(time.Hour))},
+		},
+		{
+			name:     "reserved key",
+			existing: []Reservation{reservation("a", now.Add(time.Hour))},
+			reserve:  []Reservation{reservation("a", now.Add(time.Hour))},
+			reserved: "a",
+		},
+		{
+			name:     "one of several keys reserved",
+			existing: []Reservation{reservation("b", now.Add(time.Hour))},
+			reserve:  []Reservation{reservation("a", now.Add(time.Hour)), reservation("b", now.Add(time.Hour))},
+			reserved: "b",
+		},
+		{
+			name:     "expired reservation",
+			existing: []Reservation{reservation("a", now.Add(-time.Second))},
+			reserve:  []Reservation{reservation("a", now.Add(time.Hour))},
+		},
+		{
+			name:     "expiring now",
+			existing: []Reservation{reservation("a", now)},
+			reserve:  []Reservation{reservation("a", now.Add(time.Hour))},
+		},
+	}
+
+	for kind, newStore := range testStores {
+		t.Run(kind, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					ctx := context.Background()
+					store := newStore(t)
+					// keys are unique per test, the firestore emulator is shared
+					prefix := ReservationKey(t.Name(), now.String())
+					prefixed := func(rs []Reservation) []Reservation {
+						out := make([]Reservation, 0, len(rs))
+						for _, r := range rs {
+							r.Key = prefix + r.Key
+							out = append(out, r)
+						}
+						return out
+					}
+
+					if len(tt.existing) > 0 {
+						err := store.Reserve(ctx, now.Add(-time.Hour), prefixed(tt.existing)...)
+						if err != nil {
+							t.Fatal(err)
+						}
+					}
+					err := store.Reserve(ctx, now, prefixed(tt.reserve)...)
+					if tt.reserved == "" {
+						if err != nil {
+							t.Fatalf("Reserve() = %v, want nil", err)
+						}
+						// the reservations are held now
+						err = store.Reserve(ctx, now, prefixed(tt.reserve)...)
+						var reserved *ReservedError
+						if !errors.As(err, &reserved) {
+							t.Fatalf("second Reserve() = %v, want *ReservedError", err)
+						}
+						return
+					}
+
+					var reserved *ReservedError
+					if !errors.As(err, &reserved) {
+						t.Fatalf("Reserve() = %v, want *ReservedError", err)
+					}
+					if reserved.Key != prefix+tt.reserved {
+						t.Errorf("reserved key = %s, want %s", reserved.Key, prefix+tt.reserved)
+					}
+					// nothing was reserved, so releasing the existing keys frees all of them
+					var keys []string
+					for _, r := range prefixed(tt.existing) {
+						keys = append(keys, r.Key)
+					}
+					err = store.ReleaseReservations(ctx, keys...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					err = store.Reserve(ctx, now, prefixed(tt.reserve)...)
+					if err != nil {
+						t.Errorf("Reserve() after release = %v, want nil", err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestClaimFundingReceipt(t *testing.T) {
+	for kind, newStore := range testStores {
+		t.Run(kind, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+			id, err := store.AppendFundingReceipt(ctx, FundingReceipt{Status: ReceiptQueued, Owner: "stopped"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claim := ReceiptClaim{Status: ReceiptQueued, PreviousOwner: "stopped", Owner: "a", ClaimedAt: time.Now()}
+			err = store.ClaimFundingReceipt(ctx, id, claim)
+			if err != nil {
+				t.Fatalf("ClaimFundingReceipt() = %v, want nil", err)
+			}
+			// a second process read the receipt before it was claimed
+			claim.Owner = "b"
+			err = store.ClaimFundingReceipt(ctx, id, claim)
+			if !errors.Is(err, ErrReceiptClaimed) {
+				t.Errorf("second ClaimFundingReceipt() = %v, want %v", err, ErrReceiptClaimed)
+			}
+			err = store.UpdateFundingReceipt(ctx, id, ReceiptUpdate{Status: ReceiptBroadcast, Owner: "b"})
+			if !errors.Is(err, ErrReceiptClaimed) {
+				t.Errorf("UpdateFundingReceipt() by another owner = %v, want %v", err, ErrReceiptClaimed)
+			}
+			err = store.UpdateFundingReceipt(ctx, id, ReceiptUpdate{Status: ReceiptBroadcast, Owner: "a"})
+			if err != nil {
+				t.Errorf("UpdateFundingReceipt() by the owner = %v, want nil", err)
+			}
+			receipt, err := store.GetFundingReceipt(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if receipt.Owner != "a" || receipt.Status != ReceiptBroadcast {
+				t.Errorf("receipt is %s by %q, want %s by %q", receipt.Status, receipt.Owner, ReceiptBroadcast, "a")
+			}
+		})
+	}
+}
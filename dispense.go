@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/cosmos/btcutil/bech32"
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/db"
+)
+
+// DispenseRequest is a funding request from any frontend
+type DispenseRequest struct {
+	Address string
+	// Denom optionally restricts the dispensed coins to a single denom
+	Denom    string
+	Frontend db.Frontend
+	// Requester identifies who asked for funds in the ledger
+	Requester string
+	// ClientIP is set for HTTP requests
+	ClientIP string
+	// Discord is set for requests from Discord users
+	Discord  *DiscordRequester
+	notifier Notifier
+}
+
+// DiscordRequester is the Discord user behind a request
+type DiscordRequester struct {
+	session *discordgo.Session
+	guildID string
+	userID  string
+	// member is the partial member sent along with guild events
+	member *discordgo.Member
+}
+
+// QueuedRequest is a funding request waiting on the worker of its chain
+type QueuedRequest struct {
+	db.FundingReceipt
+	// Position is the place of the request in the worker's queue
+	Position int64
+}
+
+// dispense validates a request, reserves its rate limits and queues it on
+// the worker of its chain. It returns the request's pending receipt.
+func (fh FaucetHandler) dispense(req DispenseRequest) (QueuedRequest, error) {
+	if atomic.LoadInt32(fh.closing) != 0 {
+		return QueuedRequest{}, &RequestError{Code: ErrFaucetBusy, Err: errors.New("the faucet is restarting, try again in a minute"), RetryAfter: time.Minute}
+	}
+
+	prefix, _, err := bech32.Decode(req.Address, 1023)
+	if err != nil {
+		return QueuedRequest{}, requestError(ErrInvalidAddress, err)
+	}
+
+	faucet, ok := fh.faucets[prefix]
+	if !ok {
+		return QueuedRequest{}, requestError(ErrUnsupportedChain, fmt.Errorf("%s chain prefix is not supported", prefix))
+	}
+	if !faucet.chain.Available() {
+		return QueuedRequest{}, &RequestError{Code: ErrChainUnavailable, Err: fmt.Errorf("%s is currently unavailable, try again later", prefix), RetryAfter: time.Minute}
+	}
+
+	var tier string
+	if req.Discord != nil {
+		tier, err = fh.discordTier(req.Discord, prefix)
+		if err != nil {
+			return QueuedRequest{}, requestError(ErrNotEligible, err)
+		}
+	}
+
+	coins, interval, err := tierFunding(prefix, tier)
+	if err != nil {
+		return QueuedRequest{}, err
+	}
+	if req.Denom != "" {
+		amount := coins.AmountOf(req.Denom)
+		if !amount.IsPositive() {
+			return QueuedRequest{}, requestError(ErrUnsupportedDenom, fmt.Errorf("%s is not dispensed on %s, available: %s", req.Denom, prefix, coins))
+		}
+		coins = cosmostypes.NewCoins(cosmostypes.NewCoin(req.Denom, amount))
+	}
+	fees, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Fees)
+	if err != nil {
+		return QueuedRequest{}, err
+	}
+
+	recipient, err := faucet.chain.DecodeAddr(req.Address)
+	if err != nil {
+		return QueuedRequest{}, requestError(ErrInvalidAddress, fmt.Errorf("malformed destination address, err: %w", err))
+	}
+	// the canonical lowercase form, so UMEE1... and umee1... share a cooldown
+	address, err := cosmostypes.Bech32ifyAddressBytes(prefix, recipient)
+	if err != nil {
+		return QueuedRequest{}, requestError(ErrInvalidAddress, err)
+	}
+	if isDebug {
+		log.Infof("DEBUG: recepient:  %s", address)
+	}
+
+	keys := []RateLimitKey{{RateLimitAddress, address}}
+	if req.Discord != nil {
+		keys = append(keys, RateLimitKey{RateLimitIdentity, discordIdentity(req.Discord.userID)})
+	}
+	if req.ClientIP != "" {
+		keys = append(keys, RateLimitKey{RateLimitIP, req.ClientIP})
+	}
+	release, err := fh.guard.Check(fh.ctx, prefix, coins)
+	var closed *FaucetClosedError
+	if errors.As(err, &closed) {
+		return QueuedRequest{}, &RequestError{Code: ErrFaucetClosed, Err: closed, RetryAfter: time.Until(closed.Until)}
+	}
+	if err != nil {
+		return QueuedRequest{}, err
+	}
+	// the receipt must be in the ledger before the next budget check
+	defer release()
+	reserved, err := fh.reserveFunding(prefix, req.Requester, coins, interval, keys...)
+	if err != nil {
+		return QueuedRequest{}, err
+	}
+
+	now := time.Now()
+	receipt := db.FundingReceipt{
+		ChainPrefix: prefix,
+		Recipient:   address,
+		Requester:   req.Requester,
+		Frontend:    req.Frontend,
+		FundedAt:    now,
+		Amount:      coins,
+		Fees:        fees,
+		Tier:        tier,
+		Status:      db.ReceiptQueued,
+		// persisted so the request can be replayed after a restart
+		Reservations: reserved,
+		Owner:        fh.instance,
+		ClaimedAt:    now,
+	}
+	receipt.ID, err = fh.db.AppendFundingReceipt(fh.ctx, receipt)
+	if err != nil {
+		fh.limiter.Release(fh.ctx, reserved)
+		return QueuedRequest{}, err
+	}
+
+	position, ok := faucet.enqueue(FaucetReq{
+		Recipient:    recipient,
+		Address:      address,
+		Coins:        coins,
+		Fees:         fees,
+		receiptID:    receipt.ID,
+		owner:        fh.instance,
+		reservations: reserved,
+		notifier:     req.notifier,
+	})
+	if !ok {
+		fh.limiter.Release(fh.ctx, reserved)
+		err = &RequestError{Code: ErrFaucetBusy, Err: fmt.Errorf("the %s faucet is busy, try again in a minute", prefix), RetryAfter: time.Minute}
+		updateErr := fh.db.UpdateFundingReceipt(fh.ctx, receipt.ID, db.ReceiptUpdate{Status: db.ReceiptFailed, Error: err.Error()})
+		if updateErr != nil {
+			log.Error(updateErr)
+		}
+		return QueuedRequest{}, err
+	}
+	if isDebug {
+		log.Infof("DEBUG: after faucetreq:  %s, position %d", recipient, position)
+	}
+	return QueuedRequest{receipt, position}, nil
+}
+
+// discordTier checks that a Discord user may request funds on the chain and
+// returns the funding tier of their highest role
+func (fh FaucetHandler) discordTier(d *DiscordRequester, prefix string) (string, error) {
+	err := checkAccountAge(d.userID)
+	if err != nil {
+		return "", err
+	}
+	if len(requiredRoles(prefix)) == 0 && len(funding[prefix].Tiers) == 0 && minGuildAge <= 0 {
+		return "", nil
+	}
+	member, guildID, err := guildMember(d.session, d.guildID, d.userID, d.member)
+	if err != nil {
+		return "", err
+	}
+	err = checkGuildTenure(member)
+	if err != nil {
+		return "", err
+	}
+	err = checkRoles(d.session, guildID, member, prefix)
+	if err != nil {
+		return "", err
+	}
+	return memberTier(d.session, guildID, member, prefix)
+}
+
+// reserveFunding reserves the rate limit keys of a request on the chain and
+// checks the funding caps of the requester while it holds the reservation
+func (fh FaucetHandler) reserveFunding(prefix string, requester string, coins cosmostypes.Coins, interval time.Duration, keys ...RateLimitKey) ([]string, error) {
+	if isDebug {
+		// allow unlimited faucet tapping in debug mode
+		return nil, nil
+	}
+	reserved, err := fh.limiter.Reserve(fh.ctx, prefix, interval, keys...)
+	if err != nil {
+		return nil, err
+	}
+	err = fh.checkFundingCaps(prefix, requester, coins)
+	if err != nil {
+		fh.limiter.Release(fh.ctx, reserved)
+		return nil, err
+	}
+	return reserved, nil
+}
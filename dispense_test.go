@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"github.com/xiti922/fonzie/chain"
+	"github.com/xiti922/fonzie/db"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// newTestFaucetHandler returns a handler for one connected chain whose RPC
+// node only answers /commit. No workers run, so queued requests stay queued.
+func newTestFaucetHandler(t *testing.T, store db.Store) FaucetHandler {
+	t.Helper()
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/commit" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"result":{"signed_header":{"header":{"chain_id":"umee-test"}}}}`)
+	}))
+	t.Cleanup(rpc.Close)
+
+	funding = ChainFunding{"umee": {Coins: "1000uumee", Fees: "10uumee"}}
+	fundingInterval = time.Hour
+
+	chains := chain.Chains{{Prefix: "umee", RPC: rpc.URL}}
+	if unavailable := chains.Connect(context.Background(), testMnemonic, 5*time.Second); len(unavailable) > 0 {
+		t.Fatal("test chain is unavailable")
+	}
+	return FaucetHandler{
+		faucets:   map[string]ChainFaucet{"umee": NewChainFaucet(chains[0], store)},
+		quit:      make(chan bool),
+		workers:   new(sync.WaitGroup),
+		closing:   new(int32),
+		chains:    chains,
+		db:        store,
+		limiter:   NewRateLimiter(store, nil),
+		guard:     NewSpendGuard(store),
+		ctx:       context.Background(),
+		instance:  newInstanceID(),
+		startedAt: time.Now(),
+	}
+}
+
+func testAddress(t *testing.T, b byte) string {
+	t.Helper()
+	address, err := bech32.ConvertAndEncode("umee", append(make([]byte, 19), b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+func TestDispenseConcurrentCooldown(t *testing.T) {
+	fh := newTestFaucetHandler(t, db.NewMemoryDb())
+	address := testAddress(t, 1)
+
+	const n = 20
+	errs := make(chan error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := fh.dispense(DispenseRequest{
+				Address:   address,
+				Frontend:  db.FrontendHTTP,
+				Requester: "requester",
+			})
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	var dispensed int
+	for err := range errs {
+		if err == nil {
+			dispensed += 1
+			continue
+		}
+		if code := errorCode(err); code != ErrCooldown {
+			t.Errorf("dispense() error code = %s, want %s: %v", code, ErrCooldown, err)
+		}
+	}
+	if dispensed != 1 {
+		t.Errorf("%d of %d parallel requests were dispensed, want 1", dispensed, n)
+	}
+}
+
+func TestDispenseUppercaseAddressCooldown(t *testing.T) {
+	fh := newTestFaucetHandler(t, db.NewMemoryDb())
+	address := testAddress(t, 2)
+
+	queued, err := fh.dispense(DispenseRequest{Address: address, Frontend: db.FrontendHTTP, Requester: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued.Recipient != address {
+		t.Errorf("recipient = %s, want %s", queued.Recipient, address)
+	}
+	_, err = fh.dispense(DispenseRequest{Address: strings.ToUpper(address), Frontend: db.FrontendHTTP, Requester: "b"})
+	if code := errorCode(err); code != ErrCooldown {
+		t.Errorf("dispense() to the uppercase address error code = %s, want %s: %v", code, ErrCooldown, err)
+	}
+}
+
+func TestDispenseAfterStopAccepting(t *testing.T) {
+	fh := newTestFaucetHandler(t, db.NewMemoryDb())
+	fh.StopAccepting()
+	_, err := fh.dispense(DispenseRequest{Address: testAddress(t, 4), Frontend: db.FrontendDiscord, Requester: "a"})
+	if code := errorCode(err); code != ErrFaucetBusy {
+		t.Errorf("dispense() error code = %s, want %s: %v", code, ErrFaucetBusy, err)
+	}
+}
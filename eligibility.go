@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// checkAccountAge returns an error if the Discord account of userID, dated by
+// its snowflake, is younger than MIN_ACCOUNT_AGE
+func checkAccountAge(userID string) error {
+	if minAccountAge <= 0 {
+		return nil
+	}
+	createdAt, err := discordgo.SnowflakeTimestamp(userID)
+	if err != nil {
+		return err
+	}
+	eligibleAt := createdAt.Add(minAccountAge)
+	if eligibleAt.After(time.Now()) {
+		return fmt.Errorf("your Discord account must be at least %v old, you can request funds in %v", minAccountAge, time.Until(eligibleAt).Round(time.Minute))
+	}
+	return nil
+}
+
+// checkGuildTenure returns an error if the member joined the guild less than
+// MIN_GUILD_AGE ago
+func checkGuildTenure(member *discordgo.Member) error {
+	if minGuildAge <= 0 {
+		return nil
+	}
+	if member.JoinedAt.IsZero() {
+		return fmt.Errorf("could not determine when you joined the server")
+	}
+	eligibleAt := member.JoinedAt.Add(minGuildAge)
+	if eligibleAt.After(time.Now()) {
+		return fmt.Errorf("you must be a member of the server for at least %v, you can request funds in %v", minGuildAge, time.Until(eligibleAt).Round(time.Minute))
+	}
+	return nil
+}
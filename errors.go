@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+type ErrorCode = string
+
+const (
+	ErrInvalidAddress   ErrorCode = "invalid_address"
+	ErrUnsupportedChain ErrorCode = "unsupported_chain"
+	ErrUnsupportedDenom ErrorCode = "unsupported_denom"
+	ErrNotEligible      ErrorCode = "not_eligible"
+	ErrCooldown         ErrorCode = "cooldown"
+	ErrFundingCap       ErrorCode = "funding_cap_reached"
+	ErrFaucetClosed     ErrorCode = "faucet_closed"
+	ErrFaucetBusy       ErrorCode = "faucet_busy"
+	ErrChainUnavailable ErrorCode = "chain_unavailable"
+	ErrInternal         ErrorCode = "internal"
+)
+
+// RequestError is a rejected funding request with a machine readable code
+type RequestError struct {
+	Code ErrorCode
+	Err  error
+	// RetryAfter is set when the request can succeed again later
+	RetryAfter time.Duration
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func requestError(code ErrorCode, err error) *RequestError {
+	return &RequestError{Code: code, Err: err}
+}
+
+// errorCode returns the code of a request error, ErrInternal for any other
+func errorCode(err error) ErrorCode {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Code
+	}
+	return ErrInternal
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	"github.com/xiti922/fonzie/db"
+)
+
+// activeStatuses are the ledger statuses that count towards funding caps
+var activeStatuses = []db.ReceiptStatus{db.ReceiptQueued, db.ReceiptBroadcast, db.ReceiptIncluded}
+
+// chainFundingInterval returns the funding interval of the chain, falling
+// back to FUNDING_INTERVAL
+func chainFundingInterval(prefix string) time.Duration {
+	if interval := funding[prefix].Interval.Duration; interval > 0 {
+		return interval
+	}
+	return fundingInterval
+}
+
+// tierFunding returns the coins and funding interval of a tier on the chain,
+// the empty tier being the chain's default funding
+func tierFunding(prefix string, tier string) (cosmostypes.Coins, time.Duration, error) {
+	info := funding[prefix]
+	rawCoins, interval := info.Coins, chainFundingInterval(prefix)
+	if t, ok := info.Tiers[tier]; tier != "" && ok {
+		if t.Coins != "" {
+			rawCoins = t.Coins
+		}
+		if t.Interval.Duration > 0 {
+			interval = t.Interval.Duration
+		}
+	}
+	coins, err := cosmostypes.ParseCoinsNormalized(rawCoins)
+	if err != nil {
+		return nil, 0, err
+	}
+	return coins, interval, nil
+}
+
+// checkFundingCaps returns an error if dispensing coins to requester would
+// exceed the lifetime or rolling window cap of the chain
+func (fh FaucetHandler) checkFundingCaps(prefix string, requester string, coins cosmostypes.Coins) error {
+	info := funding[prefix]
+	if info.LifetimeCap != "" {
+		err := fh.checkFundingCap(prefix, requester, coins, info.LifetimeCap, time.Time{})
+		if err != nil {
+			return fmt.Errorf("lifetime %w", err)
+		}
+	}
+	if info.WindowCap != "" && info.CapWindow.Duration > 0 {
+		err := fh.checkFundingCap(prefix, requester, coins, info.WindowCap, time.Now().Add(-info.CapWindow.Duration))
+		if err != nil {
+			return fmt.Errorf("%s %w", info.CapWindow.Duration, err)
+		}
+	}
+	return nil
+}
+
+func (fh FaucetHandler) checkFundingCap(prefix string, requester string, coins cosmostypes.Coins, rawCap CoinsStr, since time.Time) error {
+	limit, err := cosmostypes.ParseCoinsNormalized(rawCap)
+	if err != nil {
+		return err
+	}
+	receipts, err := fh.db.ListFundingReceipts(fh.ctx, db.ReceiptQuery{
+		ChainPrefix: prefix,
+		Requester:   requester,
+		Since:       since,
+		Statuses:    activeStatuses,
+	})
+	if err != nil {
+		return err
+	}
+	total := receipts.TotalAmount().Add(coins...)
+	for _, c := range limit {
+		if total.AmountOf(c.Denom).GT(c.Amount) {
+			return requestError(ErrFundingCap, fmt.Errorf("funding cap of %s on %s reached, you already received %s", limit, prefix, receipts.TotalAmount()))
+		}
+	}
+	return nil
+}
+
+// pruneCutoffs returns for each configured chain the time before which its
+// ledger receipts are no longer needed. Chains with a lifetime cap keep
+// their whole ledger, receipts of unknown chains are never pruned.
+func pruneCutoffs(now time.Time) map[db.ChainPrefix]time.Time {
+	cutoffs := make(map[db.ChainPrefix]time.Time)
+	for prefix, info := range funding {
+		if info.LifetimeCap != "" {
+			continue
+		}
+		retention := ledgerRetention
+		windows := []time.Duration{chainFundingInterval(prefix), info.CapWindow.Duration}
+		for _, window := range rateLimitWindows {
+			windows = append(windows, window)
+		}
+		for _, window := range windows {
+			if retention < window {
+				retention = window
+			}
+		}
+		cutoffs[prefix] = now.Add(-retention)
+	}
+	return cutoffs
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// interactionsHttp receives slash command interactions that Discord posts to
+// the faucet's HTTP server, so no gateway connection is needed
+func (fh FaucetHandler) interactionsHttp(s *discordgo.Session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !discordgo.VerifyInteraction(r, discordPublicKey) {
+			http.Error(w, "invalid request signature", http.StatusUnauthorized)
+			return
+		}
+
+		var i discordgo.Interaction
+		err := json.NewDecoder(r.Body).Decode(&i)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var resp discordgo.InteractionResponse
+		switch i.Type {
+		case discordgo.InteractionPing:
+			resp.Type = discordgo.InteractionResponsePong
+		case discordgo.InteractionApplicationCommand:
+			resp = deferredEphemeralResponse
+		default:
+			http.Error(w, "unsupported interaction type", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if i.Type == discordgo.InteractionApplicationCommand {
+			// reply once the command completed, the response above only
+			// acknowledged the interaction
+			go fh.completeInteraction(s, &i)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestInteractionsHttpSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discordPublicKey = publicKey
+	defer func() { discordPublicKey = nil }()
+
+	const timestamp = "1700000000"
+	ping := `{"type":1}`
+	sign := func(key ed25519.PrivateKey, timestamp string, body string) string {
+		return hex.EncodeToString(ed25519.Sign(key, []byte(timestamp+body)))
+	}
+	tests := []struct {
+		name      string
+		body      string
+		timestamp string
+		signature string
+		want      int
+	}{
+		{"valid signature", ping, timestamp, sign(privateKey, timestamp, ping), http.StatusOK},
+		{"tampered body", `{"type":2}`, timestamp, sign(privateKey, timestamp, ping), http.StatusUnauthorized},
+		{"tampered timestamp", ping, "1700000001", sign(privateKey, timestamp, ping), http.StatusUnauthorized},
+		{"signed by another key", ping, timestamp, sign(otherKey, timestamp, ping), http.StatusUnauthorized},
+		{"missing signature", ping, timestamp, "", http.StatusUnauthorized},
+	}
+	handler := FaucetHandler{}.interactionsHttp(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/interactions", bytes.NewBufferString(tt.body))
+			r.Header.Set("X-Signature-Timestamp", tt.timestamp)
+			if tt.signature != "" {
+				r.Header.Set("X-Signature-Ed25519", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var resp discordgo.InteractionResponse
+			err := json.NewDecoder(w.Body).Decode(&resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Type != discordgo.InteractionResponsePong {
+				t.Errorf("response type = %d, want PONG", resp.Type)
+			}
+		})
+	}
+}
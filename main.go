@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"os"
@@ -15,7 +22,6 @@ import (
 	"syscall"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/cosmos/btcutil/bech32"
 	cosmostypes "github.com/cosmos/cosmos-sdk/types"
 	log "github.com/sirupsen/logrus"
 	"github.com/xiti922/fonzie/chain"
@@ -34,6 +40,29 @@ type FeesStr = string
 type ChainFundingInfo struct {
 	Coins CoinsStr `json:"coins"`
 	Fees  FeesStr  `json:"fees"`
+	// Interval overrides FUNDING_INTERVAL for this chain
+	Interval chain.Duration `json:"interval"`
+	// LifetimeCap is the most one identity can ever receive on this chain
+	LifetimeCap CoinsStr `json:"lifetime_cap"`
+	// WindowCap is the most one identity can receive within CapWindow
+	WindowCap CoinsStr       `json:"window_cap"`
+	CapWindow chain.Duration `json:"cap_window"`
+	// Budget is the most the chain dispenses within BudgetWindow
+	Budget       CoinsStr       `json:"budget"`
+	BudgetWindow chain.Duration `json:"budget_window"`
+	// SpikeFactor trips the circuit breaker for BreakerCooldown when the last
+	// hour's dispenses exceed this multiple of the hourly baseline
+	SpikeFactor     float64        `json:"spike_factor"`
+	BreakerCooldown chain.Duration `json:"breaker_cooldown"`
+	// Roles overrides ROLE_REQUIRED for this chain
+	Roles []string `json:"roles"`
+	// Tiers are keyed by Discord role ID. Members get the tier of their
+	// highest role instead of the default coins and interval.
+	Tiers map[string]FundingTier `json:"tiers"`
+}
+type FundingTier struct {
+	Coins    CoinsStr       `json:"coins"`
+	Interval chain.Duration `json:"interval"`
 }
 type ChainFunding = map[db.ChainPrefix]ChainFundingInfo
 
@@ -44,14 +73,41 @@ var (
 	rawChains          = os.Getenv("CHAINS")
 	rawFunding         = os.Getenv("FUNDING")
 	rawFundingInterval = os.Getenv("FUNDING_INTERVAL")
+	storeKind          = os.Getenv("STORE")
+	storePath          = os.Getenv("STORE_PATH")
+	rawLedgerRetention = os.Getenv("LEDGER_RETENTION")
+	rawTrustForwarded  = os.Getenv("TRUST_FORWARDED_FOR")
+	rawRoleRequired    = os.Getenv("ROLE_REQUIRED")
+	homeGuildID        = os.Getenv("HOME_GUILD_ID")
+	rawMinAccountAge   = os.Getenv("MIN_ACCOUNT_AGE")
+	rawMinGuildAge     = os.Getenv("MIN_GUILD_AGE")
+	legacyCommands     = os.Getenv("LEGACY_COMMANDS") != "false" && os.Getenv("LEGACY_COMMANDS") != "0"
+	interactionsMode   = os.Getenv("INTERACTIONS_MODE")
+	rawPublicKey       = os.Getenv("DISCORD_PUBLIC_KEY")
+	discordAppID       = os.Getenv("DISCORD_APP_ID")
+	rawShutdownGrace   = os.Getenv("SHUTDOWN_GRACE_PERIOD")
+	rawChainTimeout    = os.Getenv("CHAIN_TIMEOUT")
 	isSilent           = os.Getenv("SILENT") != ""
 	isDebug            = os.Getenv("DEBUG") != ""
 	funding            ChainFunding
 	fundingInterval    time.Duration
+	ledgerRetention    time.Duration
+	rateLimitWindows   = make(map[RateLimitKind]time.Duration)
+	trustedProxyHops   int
+	globalRoles        []string
+	minAccountAge      time.Duration
+	minGuildAge        time.Duration
+	discordPublicKey   ed25519.PublicKey
+	shutdownGrace      time.Duration
+	chainTimeout       time.Duration
 	pruneMode          = false
+	reportMode         = false
+	reportPeriod       = time.Hour * 24 * 7
+	rebalanceMode      = false
 )
 
-func init() {
+// loadConfig parses the environment, it exits when the config is invalid
+func loadConfig() {
 	foo := customlens.CustomChainClient{}
 	log.Println(foo)
 	if len(os.Args) > 1 {
@@ -62,6 +118,19 @@ func init() {
 		if os.Args[1] == "prune" {
 			pruneMode = true
 		}
+		if os.Args[1] == "rebalance" {
+			rebalanceMode = true
+		}
+		if os.Args[1] == "report" {
+			reportMode = true
+			if len(os.Args) > 2 {
+				var err error
+				reportPeriod, err = time.ParseDuration(os.Args[2])
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
 
 	}
 
@@ -102,6 +171,92 @@ func init() {
 			log.Fatal(err)
 		}
 	}
+	for kind, env := range map[RateLimitKind]string{
+		RateLimitIdentity: "RATE_LIMIT_IDENTITY_INTERVAL",
+		RateLimitAddress:  "RATE_LIMIT_ADDRESS_INTERVAL",
+		RateLimitIP:       "RATE_LIMIT_IP_INTERVAL",
+	} {
+		rawWindow := os.Getenv(env)
+		if rawWindow == "" {
+			// defaults to the funding interval of each chain
+			continue
+		}
+		window, err := time.ParseDuration(rawWindow)
+		if err != nil {
+			log.Fatalf("%s: %v", env, err)
+		}
+		rateLimitWindows[kind] = window
+	}
+	if rawTrustForwarded != "" {
+		hops, err := strconv.Atoi(rawTrustForwarded)
+		if err != nil {
+			// any other value trusts the proxy in front of the faucet
+			hops = 1
+		}
+		if hops < 0 {
+			log.Fatal("TRUST_FORWARDED_FOR must be a positive number of proxies")
+		}
+		trustedProxyHops = hops
+	}
+	for _, role := range strings.Split(rawRoleRequired, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			globalRoles = append(globalRoles, role)
+		}
+	}
+	if rawMinAccountAge != "" {
+		var err error
+		minAccountAge, err = time.ParseDuration(rawMinAccountAge)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if rawMinGuildAge != "" {
+		var err error
+		minGuildAge, err = time.ParseDuration(rawMinGuildAge)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if interactionsMode == "http" {
+		key, err := hex.DecodeString(rawPublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			log.Fatal("DISCORD_PUBLIC_KEY is invalid (hex encoded ed25519 key of the application)")
+		}
+		discordPublicKey = key
+		if legacyCommands {
+			log.Info("INTERACTIONS_MODE is http, legacy text commands are disabled")
+			legacyCommands = false
+		}
+	} else if interactionsMode != "" && interactionsMode != "gateway" {
+		log.Fatal("INTERACTIONS_MODE must be gateway or http")
+	}
+	if rawShutdownGrace == "" {
+		shutdownGrace = time.Second * 30
+	} else {
+		var err error
+		shutdownGrace, err = time.ParseDuration(rawShutdownGrace)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if rawChainTimeout == "" {
+		chainTimeout = time.Second * 10
+	} else {
+		var err error
+		chainTimeout, err = time.ParseDuration(rawChainTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if rawLedgerRetention == "" {
+		ledgerRetention = time.Hour * 24 * 90
+	} else {
+		var err error
+		ledgerRetention, err = time.ParseDuration(rawLedgerRetention)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func initChains() chain.Chains {
@@ -112,61 +267,133 @@ func initChains() chain.Chains {
 		log.Fatal(err)
 	}
 	log.Printf("CHAINS: %#v", chains)
-	// parse chains config
-	err = json.Unmarshal([]byte(rawFunding), &funding)
+	initFunding()
+	return chains
+}
+
+func initFunding() {
+	// parse funding config
+	err := json.Unmarshal([]byte(rawFunding), &funding)
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("CHAIN_FUNDING: %#v", funding)
-	return chains
 }
 
 func main() {
-	ctx := context.Background()
-	db := db.NewDb(ctx)
+	loadConfig()
+	// the root context is cancelled on CTRL-C or other term signal
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	store, err := db.NewStore(ctx, storeKind, storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	if pruneMode {
-		numPruned, err := db.PruneExpiredReceipts(ctx, time.Now().Add(-fundingInterval))
+		initFunding()
+		err := migrateLegacyReceipts(ctx, store)
+		if err != nil {
+			log.Fatal(err)
+		}
+		numPruned, err := store.PruneExpiredReceipts(ctx, pruneCutoffs(time.Now()))
 		if err != nil {
 			log.Fatal(err)
 		}
 		log.Infof("pruned %d receipts", numPruned)
+		numPruned, err = store.PruneExpiredReservations(ctx, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("pruned %d reservations", numPruned)
 		os.Exit(0)
 	}
 
-	chains := initChains()
+	if reportMode {
+		err := printSpendReport(ctx, store, time.Now().Add(-reportPeriod))
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
-	err := chains.ImportMnemonic(ctx, mnemonic)
+	chains := initChains()
+	err = migrateLegacyReceipts(ctx, store)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// chains whose RPC is down are retried in the background once the
+	// faucet is running, the healthy ones are served in the meantime
+	unavailable := chains.Connect(ctx, mnemonic, chainTimeout)
+
+	if rebalanceMode {
+		err := rebalance(ctx, chains)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
 	// Create a new Discord session using the provided bot token.
 	dg, err := discordgo.New("Bot " + botToken)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer dg.Close()
-
-	fh := NewFaucetHandler(chains, db)
-	dg.AddHandler(fh.handleDispense)
 
-	// we only care about receiving message events.
-	dg.Identify.Intents = discordgo.IntentsGuildMessages
-	// dg.Identify.Intents = discordgo.IntentDirectMessages
+	fh := NewFaucetHandler(chains, store)
+	for _, c := range unavailable {
+		go c.KeepConnecting(ctx, mnemonic, chainTimeout)
+	}
+	// requests persisted by stopped faucet processes
+	go fh.keepReplaying(ctx)
+	if interactionsMode == "http" {
+		// Discord posts interactions to our HTTP server, the REST API is
+		// enough to reply so we don't open a gateway connection
+		if discordAppID == "" {
+			app, err := dg.User("@me")
+			if err != nil {
+				log.Fatal(err)
+			}
+			discordAppID = app.ID
+		}
+		http.HandleFunc("/interactions", fh.interactionsHttp(dg))
+	} else {
+		dg.AddHandler(fh.handleInteraction)
+		dg.Identify.Intents = discordgo.IntentsGuilds
+		if legacyCommands {
+			// the !request and !help text commands need message events
+			dg.AddHandler(fh.handleDispense)
+			dg.Identify.Intents |= discordgo.IntentsGuildMessages
+		}
 
-	// Open a websocket connection to Discord and begin listening.
-	err = dg.Open()
+		// Open a websocket connection to Discord and begin listening.
+		err = dg.Open()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if discordAppID == "" {
+			discordAppID = dg.State.User.ID
+		}
+	}
+	err = registerCommands(dg, discordAppID)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Create http endpoint for faucet requests
+	// Create http endpoints for faucet requests
 	http.HandleFunc("/", fh.faucetHttp)
-	log.Printf("listening on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
-	}
+	http.HandleFunc("/api/v1/requests", fh.apiRequests)
+	http.HandleFunc(requestsPath, fh.apiRequest)
+	http.HandleFunc("/api/v1/chains", fh.apiChains)
+	fh.handleCosmjs()
+	srv := &http.Server{Addr: ":" + port}
+	go func() {
+		log.Printf("listening on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 
 	// Wait here until CTRL-C or other term signal is received.
 	if isSilent {
@@ -174,49 +401,128 @@ func main() {
 	} else {
 		log.Info("The Fonz bot is now thumbs-up'ing.  Press CTRL-C to exit.")
 	}
-	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	<-sc
+	<-ctx.Done()
+	stop()
+	log.Infof("shutting down, waiting up to %s for pending requests", shutdownGrace)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	// Discord keeps delivering commands while the HTTP server drains, both
+	// frontends stop accepting requests at once
+	fh.StopAccepting()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error(err)
+	}
+	if err := fh.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("pending requests are replayed on the next start: %v", err)
+	}
+	if err := dg.Close(); err != nil {
+		log.Error(err)
+	}
+	log.Info("The Fonz is out.  Ayyy!")
 }
 
 type FaucetHandler struct {
 	faucets map[string]ChainFaucet
 	quit    chan bool
+	workers *sync.WaitGroup
+	closing *int32
 	chains  chain.Chains
-	db      db.Db
+	db      db.Store
+	limiter RateLimiter
+	guard   *SpendGuard
 	ctx     context.Context
+	// instance owns the receipts of this process, receipts funded before
+	// startedAt are replayed from the ledger
+	instance  string
+	startedAt time.Time
 
 	cmd *regexp.Regexp
 }
 
-func NewFaucetHandler(chains chain.Chains, db db.Db) FaucetHandler {
+func NewFaucetHandler(chains chain.Chains, db db.Store) FaucetHandler {
 	re, err := regexp.Compile("!(request|help)(.*)")
 	if err != nil {
 		log.Fatal(err)
 	}
 	var faucets = make(map[string]ChainFaucet)
 	var quit = make(chan bool)
+	var workers sync.WaitGroup
 	for _, c := range chains {
-		f := ChainFaucet{make(chan FaucetReq), c}
+		f := NewChainFaucet(c, db)
 		faucets[c.Prefix] = f
-		go f.Consume(quit)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			f.Consume(quit)
+		}()
 	}
 	return FaucetHandler{
-		faucets: faucets,
-		quit:    quit,
-		chains:  chains,
-		cmd:     re,
-		ctx:     context.Background(),
-		db:      db,
+		faucets:   faucets,
+		quit:      quit,
+		workers:   &workers,
+		closing:   new(int32),
+		chains:    chains,
+		cmd:       re,
+		ctx:       context.Background(),
+		db:        db,
+		limiter:   NewRateLimiter(db, rateLimitWindows),
+		guard:     NewSpendGuard(db),
+		instance:  newInstanceID(),
+		startedAt: time.Now(),
+	}
+}
+
+// newInstanceID identifies the faucet process in the ledger
+func newInstanceID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%x", hostname, id)
+}
+
+// StopAccepting rejects new requests from every frontend with ErrFaucetBusy
+func (fh FaucetHandler) StopAccepting() {
+	atomic.StoreInt32(fh.closing, 1)
+}
+
+// Shutdown stops accepting requests and waits until the workers flushed
+// their pending batches and the broadcast txs are settled, or ctx is done.
+// The requests left pending are released to the next faucet process.
+func (fh FaucetHandler) Shutdown(ctx context.Context) error {
+	fh.StopAccepting()
+	close(fh.quit)
+	done := make(chan struct{})
+	go func() {
+		fh.workers.Wait()
+		close(done)
+	}()
+	defer func() {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		fh.releaseReceipts(releaseCtx)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 func (fh FaucetHandler) faucetHttp(w http.ResponseWriter, r *http.Request) {
 	// only handle GET requests
-	if r.Method != "GET" && r.URL.Path != "/" {
+	if r.Method != "GET" || r.URL.Path != "/" {
 		if isDebug {
 			log.Printf("DEBUG: received non-usable request: %#v", r)
 		}
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
 		return
 	}
 	if isDebug {
@@ -224,87 +530,42 @@ func (fh FaucetHandler) faucetHttp(w http.ResponseWriter, r *http.Request) {
 	}
 	query := r.URL.Query()
 	if !query.Has("wallet") {
-		httpError(w, "wallet is required")
+		httpError(w, "wallet is required", http.StatusBadRequest)
 		return
 	}
 	wallet := strings.TrimSpace(query.Get("wallet"))
-	prefix, _, err := bech32.Decode(wallet, 1023)
-	if err != nil {
-		httpError(w, prefix+" is not supported: "+err.Error())
-		return
-	}
-
-	faucet, ok := fh.faucets[prefix]
-	if !ok {
-		httpError(w, prefix+" is not supported"+err.Error())
-		return
-	}
-	coins, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Coins)
-	if err != nil {
-		httpError(w, "parsing coins: "+err.Error())
-		return
-	}
-	fees, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Fees)
-	if err != nil {
-		httpError(w, "parsing fees: "+err.Error())
-		return
-	}
-
-	log.Infof("request from %s", r.RemoteAddr)
-	receipt, err := fh.db.GetFundingReceiptByUsernameAndChainPrefix(fh.ctx, wallet, prefix)
-	if isDebug {
-		log.Infof("DEBUG: after funding receipt %v", receipt)
-	}
-	if err != nil {
-		httpError(w, err.Error())
-		return
-	}
-
+	ip := clientIP(r)
+	log.Infof("request from %s", ip)
 	if isDebug {
 		log.Infof("DEBUG: wallet is %s", wallet)
 	}
-	if receipt != nil {
-		log.Infof("FETCHED RECEIPT RESULT: %#v", receipt.FundedAt.Add(fundingInterval).After(time.Now()))
-	}
-
-	if receipt != nil && receipt.FundedAt.Add(fundingInterval).After(time.Now()) {
-		httpError(w, (fmt.Errorf("you must wait %v until you can get %s funding again", time.Until(receipt.FundedAt.Add(fundingInterval)).Round(2*time.Second), prefix).Error()))
-		return
-	}
-
-	recipient, err := faucet.chain.DecodeAddr(wallet)
-	if isDebug {
-		log.Infof("DEBUG: recepient:  %s", recipient)
-	}
-	if err != nil {
-		httpError(w, "malformed destination address: "+err.Error())
-		return
-	}
-
-	faucet.channel <- FaucetReq{recipient, coins, fees, nil, nil}
-	if isDebug {
-		log.Infof("DEBUG: after faucetreq:  %s", recipient)
-	}
-	err = fh.db.SaveFundingReceipt(fh.ctx, db.FundingReceipt{
-		ChainPrefix: prefix,
-		Username:    wallet,
-		FundedAt:    time.Now(),
-		Amount:      coins,
+	queued, err := fh.dispense(DispenseRequest{
+		Address:   wallet,
+		Frontend:  db.FrontendHTTP,
+		Requester: ip,
+		ClientIP:  ip,
 	})
 	if err != nil {
-		log.Error(err)
+		status := http.StatusBadRequest
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) {
+			status = errorStatus[reqErr.Code]
+		}
+		setRetryAfter(w, err)
+		httpError(w, err.Error(), status)
+		return
 	}
 	// success
-	fmt.Fprintf(w, "%s faucet tokens sent to wallet: %s", prefix, wallet)
+	fmt.Fprintf(w, "%s faucet tokens queued for wallet: %s, request id: %s, queue position: %d", queued.ChainPrefix, wallet, queued.ID, queued.Position)
 }
 
-func httpError(w http.ResponseWriter, err string) {
+func httpError(w http.ResponseWriter, err string, status int) {
 	if isDebug {
 		log.Infof("DEBUG httpError:  %s", err)
 	}
 	w.Header().Set("x-faucet-error", err)
 	log.Errorf(err)
-	defer w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(status)
 }
 
 // This function will be called (due to AddHandler above) every time a new
@@ -325,66 +586,25 @@ func (fh FaucetHandler) handleDispense(s *discordgo.Session, m *discordgo.Messag
 			args := strings.TrimSpace(match[2])
 			switch cmd {
 			case "request":
-				// TODO if role doesn't exist, reply with help and return
-				// - "umeemaniac"
-				// - ROLE_REQUIRED="role string/id", optional from env
-				dstAddr := args
-				prefix, _, err := bech32.Decode(dstAddr, 1023)
-				if err != nil {
-					reportError(s, m, err)
-					return
-				}
-
-				faucet, ok := fh.faucets[prefix]
-				if !ok {
-					reportError(s, m, fmt.Errorf("%s chain prefix is not supported", prefix))
-					return
-				}
-				coins, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Coins)
-				if err != nil {
-					reportError(s, m, err)
-					return
-				}
-				fees, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Fees)
+				queued, err := fh.dispense(DispenseRequest{
+					Address:   args,
+					Frontend:  db.FrontendDiscord,
+					Requester: m.Author.ID,
+					Discord: &DiscordRequester{
+						session: s,
+						guildID: m.GuildID,
+						userID:  m.Author.ID,
+						member:  m.Member,
+					},
+					notifier: messageNotifier{s, m},
+				})
 				if err != nil {
 					reportError(s, m, err)
 					return
 				}
-
-				receipt, err := fh.db.GetFundingReceiptByUsernameAndChainPrefix(fh.ctx, m.Author.ID, prefix)
-				if err != nil {
-					log.Error(err)
-					return
-				}
-
-				if receipt != nil {
-					log.Infof("FETCHED RECEIPT RESULT: %#v", receipt.FundedAt.Add(fundingInterval).After(time.Now()))
-				}
-
-				if receipt != nil && receipt.FundedAt.Add(fundingInterval).After(time.Now()) {
-					reportError(s, m, fmt.Errorf("you must wait %v until you can get %s funding again", time.Until(receipt.FundedAt.Add(fundingInterval)).Round(2*time.Second), prefix))
-					return
-				}
-
-				recipient, err := faucet.chain.DecodeAddr(dstAddr)
-				if err != nil {
-					reportError(s, m, fmt.Errorf("malformed destination address, err: %w", err))
-					return
-				}
-
 				// Immediately respond to Discord
 				sendReaction(s, m, "👍")
-				faucet.channel <- FaucetReq{recipient, coins, fees, s, m}
-
-				err = fh.db.SaveFundingReceipt(fh.ctx, db.FundingReceipt{
-					ChainPrefix: prefix,
-					Username:    m.Author.ID,
-					FundedAt:    time.Now(),
-					Amount:      coins,
-				})
-				if err != nil {
-					log.Error(err)
-				}
+				sendMessage(s, m, fmt.Sprintf("Your request for `%s` is #%d in the queue", queued.Recipient, queued.Position))
 
 			default:
 				help(s, m, fh.chains)
@@ -396,6 +616,22 @@ func (fh FaucetHandler) handleDispense(s *discordgo.Session, m *discordgo.Messag
 	}
 }
 
+// messageNotifier reports the outcome of a text command request
+type messageNotifier struct {
+	session *discordgo.Session
+	msg     *discordgo.MessageCreate
+}
+
+func (n messageNotifier) Dispensed(r FaucetReq, res *cosmostypes.TxResponse) {
+	// Everything worked, so-- respond successfully to Discord requester
+	sendReaction(n.session, n.msg, "✅")
+	sendMessage(n.session, n.msg, fmt.Sprintf("Dispensed 💸 `%s` to `%s` in block %d, tx `%s`", r.Coins, r.Address, res.Height, res.TxHash))
+}
+
+func (n messageNotifier) Failed(r FaucetReq, err error) {
+	reportError(n.session, n.msg, err)
+}
+
 func reportError(s *discordgo.Session, m *discordgo.MessageCreate, errToReport error) {
 	if s == nil || m == nil {
 		// if no session or message, plainly log the error
@@ -421,16 +657,28 @@ func reportError(s *discordgo.Session, m *discordgo.MessageCreate, errToReport e
 var helpMsg string
 
 func help(s *discordgo.Session, m *discordgo.MessageCreate, chains chain.Chains) {
-	acc := []string{}
-	for _, chain := range chains {
-		acc = append(acc, chain.Prefix)
-	}
-	err := sendMessage(s, m, fmt.Sprintf("**Supported address prefixes**: %s.\n\n%s", strings.Join(acc, ", "), helpMsg))
+	err := sendMessage(s, m, helpText(chains))
 	if err != nil {
 		log.Error(err)
 	}
 }
 
+func helpText(chains chain.Chains) string {
+	text := fmt.Sprintf("**Supported address prefixes**: %s.\n\n", strings.Join(chainPrefixes(chains), ", "))
+	if unavailable := chains.Unavailable(); len(unavailable) > 0 {
+		text += fmt.Sprintf("**Currently unavailable**: %s.\n\n", strings.Join(chainPrefixes(unavailable), ", "))
+	}
+	return text + helpMsg
+}
+
+func chainPrefixes(chains chain.Chains) []string {
+	acc := []string{}
+	for _, c := range chains {
+		acc = append(acc, c.Prefix)
+	}
+	return acc
+}
+
 func isDM(m *discordgo.MessageCreate) bool {
 	return m.GuildID == ""
 }
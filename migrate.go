@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cosmos/btcutil/bech32"
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/db"
+)
+
+// migrateLegacyReceipts moves the receipts kept before the funding ledger
+// into it. Receipts still in their funding interval also get the cooldown
+// reservation of their requester, so the migration doesn't reset it. The
+// legacy receipts are deleted afterwards, which makes this a one-time step.
+func migrateLegacyReceipts(ctx context.Context, store db.Store) error {
+	legacy, ok := store.(db.LegacyStore)
+	if !ok {
+		return nil
+	}
+	receipts, err := legacy.LegacyFundingReceipts(ctx)
+	if err != nil {
+		return err
+	}
+	if len(receipts) == 0 {
+		return nil
+	}
+	log.Infof("migrating %d legacy funding receipts", len(receipts))
+
+	now := time.Now()
+	ids := make([]string, 0, len(receipts))
+	for _, r := range receipts {
+		ids = append(ids, r.ID)
+		key, frontend := legacyRateLimitKey(r)
+		window, ok := rateLimitWindows[key.Kind]
+		if !ok {
+			window = chainFundingInterval(r.ChainPrefix)
+		}
+		expiresAt := r.FundedAt.Add(window)
+
+		receipt := db.FundingReceipt{
+			ChainPrefix: r.ChainPrefix,
+			Requester:   r.Username,
+			Frontend:    frontend,
+			FundedAt:    r.FundedAt,
+			Amount:      r.Amount,
+			Status:      db.ReceiptIncluded,
+		}
+		if key.Kind == RateLimitAddress {
+			receipt.Recipient = key.Value
+		}
+		if expiresAt.After(now) {
+			reservation := db.Reservation{
+				Key:        db.ReservationKey(key.Kind, key.Value, r.ChainPrefix),
+				ReservedAt: r.FundedAt,
+				ExpiresAt:  expiresAt,
+			}
+			err := store.Reserve(ctx, now, reservation)
+			var reserved *db.ReservedError
+			if err != nil && !errors.As(err, &reserved) {
+				return err
+			}
+			receipt.Reservations = []string{reservation.Key}
+		}
+		_, err := store.AppendFundingReceipt(ctx, receipt)
+		if err != nil {
+			return err
+		}
+	}
+	return legacy.DeleteLegacyFundingReceipts(ctx, ids...)
+}
+
+// legacyRateLimitKey returns the key a legacy receipt was limited by. HTTP
+// requests were limited by the wallet address, Discord requests by the
+// author's user ID.
+func legacyRateLimitKey(r db.LegacyFundingReceipt) (RateLimitKey, db.Frontend) {
+	if _, _, err := bech32.Decode(r.Username, 1023); err == nil {
+		return RateLimitKey{RateLimitAddress, strings.ToLower(r.Username)}, db.FrontendHTTP
+	}
+	return RateLimitKey{RateLimitIdentity, discordIdentity(r.Username)}, db.FrontendDiscord
+}
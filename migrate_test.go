@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xiti922/fonzie/db"
+)
+
+// legacyMemoryDb is a memory store that also holds legacy receipts
+type legacyMemoryDb struct {
+	*db.MemoryDb
+	legacy []db.LegacyFundingReceipt
+}
+
+func (m *legacyMemoryDb) LegacyFundingReceipts(ctx context.Context) ([]db.LegacyFundingReceipt, error) {
+	return m.legacy, nil
+}
+
+func (m *legacyMemoryDb) DeleteLegacyFundingReceipts(ctx context.Context, ids ...string) error {
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	var kept []db.LegacyFundingReceipt
+	for _, r := range m.legacy {
+		if !deleted[r.ID] {
+			kept = append(kept, r)
+		}
+	}
+	m.legacy = kept
+	return nil
+}
+
+func TestMigrateLegacyReceipts(t *testing.T) {
+	address := testAddress(t, 5)
+	expired := testAddress(t, 6)
+	store := &legacyMemoryDb{
+		MemoryDb: db.NewMemoryDb(),
+		legacy: []db.LegacyFundingReceipt{
+			{ID: "a", ChainPrefix: "umee", Username: address, FundedAt: time.Now().Add(-time.Minute)},
+			{ID: "b", ChainPrefix: "umee", Username: "1234", FundedAt: time.Now().Add(-time.Minute)},
+			{ID: "c", ChainPrefix: "umee", Username: expired, FundedAt: time.Now().Add(-2 * time.Hour)},
+		},
+	}
+	fh := newTestFaucetHandler(t, store)
+
+	err := migrateLegacyReceipts(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.legacy) != 0 {
+		t.Errorf("%d legacy receipts are left, want 0", len(store.legacy))
+	}
+	receipts, err := store.ListFundingReceipts(context.Background(), db.ReceiptQuery{ChainPrefix: "umee"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 3 {
+		t.Errorf("%d receipts in the ledger, want 3", len(receipts))
+	}
+
+	_, err = fh.dispense(DispenseRequest{Address: address, Frontend: db.FrontendHTTP, Requester: "a"})
+	if code := errorCode(err); code != ErrCooldown {
+		t.Errorf("dispense() to a migrated address error code = %s, want %s: %v", code, ErrCooldown, err)
+	}
+	_, err = fh.dispense(DispenseRequest{Address: expired, Frontend: db.FrontendHTTP, Requester: "b"})
+	if err != nil {
+		t.Errorf("dispense() to an address whose cooldown expired = %v, want nil", err)
+	}
+}
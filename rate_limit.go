@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/db"
+)
+
+type RateLimitKind = string
+
+const (
+	// RateLimitIdentity limits the requester, e.g. a Discord user
+	RateLimitIdentity RateLimitKind = "identity"
+	// RateLimitAddress limits the destination address, whatever the frontend
+	RateLimitAddress RateLimitKind = "address"
+	// RateLimitIP limits the client IP of HTTP requests
+	RateLimitIP RateLimitKind = "ip"
+)
+
+type RateLimitKey struct {
+	Kind  RateLimitKind
+	Value string
+}
+
+// RateLimiter checks several keys of a request at once, each with its own
+// window. A request is denied if any of its keys is still in cooldown. Kinds
+// without a configured window use the funding interval of the chain.
+type RateLimiter struct {
+	store   db.Store
+	windows map[RateLimitKind]time.Duration
+}
+
+func NewRateLimiter(store db.Store, windows map[RateLimitKind]time.Duration) RateLimiter {
+	return RateLimiter{
+		store:   store,
+		windows: windows,
+	}
+}
+
+// Reserve atomically reserves all keys on the chain and returns the
+// reservation keys to release if the request is not dispensed after all
+func (rl RateLimiter) Reserve(ctx context.Context, prefix string, interval time.Duration, keys ...RateLimitKey) ([]string, error) {
+	now := time.Now()
+	var reservations []db.Reservation
+	kinds := make(map[string]RateLimitKind)
+	for _, k := range keys {
+		window, ok := rl.windows[k.Kind]
+		if !ok {
+			window = interval
+		}
+		if window <= 0 || k.Value == "" {
+			// this kind of key is not limited
+			continue
+		}
+		key := db.ReservationKey(k.Kind, k.Value, prefix)
+		kinds[key] = k.Kind
+		reservations = append(reservations, db.Reservation{
+			Key:        key,
+			ReservedAt: now,
+			ExpiresAt:  now.Add(window),
+		})
+	}
+	if len(reservations) == 0 {
+		return nil, nil
+	}
+
+	err := rl.store.Reserve(ctx, now, reservations...)
+	var reserved *db.ReservedError
+	if errors.As(err, &reserved) {
+		log.Infof("FETCHED RESERVATION RESULT: %s key reserved until %v", kinds[reserved.Key], reserved.Until)
+		return nil, &RequestError{
+			Code:       ErrCooldown,
+			Err:        fmt.Errorf("this %s must wait %v until it can get %s funding again", kinds[reserved.Key], time.Until(reserved.Until).Round(2*time.Second), prefix),
+			RetryAfter: time.Until(reserved.Until),
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keysToRelease := make([]string, 0, len(reservations))
+	for _, r := range reservations {
+		keysToRelease = append(keysToRelease, r.Key)
+	}
+	return keysToRelease, nil
+}
+
+// ReservedUntil returns when the last reservation of the keys on the chain
+// expires, or the zero time when none of them is reserved
+func (rl RateLimiter) ReservedUntil(ctx context.Context, prefix string, keys ...RateLimitKey) (time.Time, error) {
+	reservationKeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		reservationKeys = append(reservationKeys, db.ReservationKey(k.Kind, k.Value, prefix))
+	}
+	reservations, err := rl.store.GetReservations(ctx, reservationKeys...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var until time.Time
+	now := time.Now()
+	for _, r := range reservations {
+		if r.ExpiresAt.After(now) && r.ExpiresAt.After(until) {
+			until = r.ExpiresAt
+		}
+	}
+	return until, nil
+}
+
+// Release removes reservations of a request that won't be dispensed
+func (rl RateLimiter) Release(ctx context.Context, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	err := rl.store.ReleaseReservations(ctx, keys...)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// clientIP returns the IP of an HTTP caller. Behind trusted proxies it is
+// the X-Forwarded-For entry appended by the outermost of them, entries left
+// of it are sent by the client and can't be trusted.
+func clientIP(r *http.Request) string {
+	if trustedProxyHops > 0 {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			entries := strings.Split(strings.Join(forwarded, ","), ",")
+			i := len(entries) - trustedProxyHops
+			if i < 0 {
+				i = 0
+			}
+			if ip := strings.TrimSpace(entries[i]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func discordIdentity(userID string) string {
+	return "discord:" + userID
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		hops      int
+		forwarded []string
+		want      string
+	}{
+		{"no proxy ignores the header", 0, []string{"1.1.1.1"}, "192.0.2.1"},
+		{"one proxy", 1, []string{"6.6.6.6, 1.1.1.1"}, "1.1.1.1"},
+		{"two proxies", 2, []string{"6.6.6.6, 1.1.1.1, 10.0.0.1"}, "1.1.1.1"},
+		{"repeated headers", 1, []string{"6.6.6.6", "1.1.1.1"}, "1.1.1.1"},
+		{"fewer entries than proxies", 3, []string{"1.1.1.1, 10.0.0.1"}, "1.1.1.1"},
+		{"missing header", 1, nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trustedProxyHops = tt.hops
+			defer func() { trustedProxyHops = 0 }()
+			r := httptest.NewRequest("GET", "/", nil)
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/chain"
+)
+
+// rebalance tops up the sub-accounts of every chain from the primary
+// account, so each signer holds an equal share of the dispensed denoms
+func rebalance(ctx context.Context, chains chain.Chains) error {
+	for _, c := range chains {
+		if !c.Available() {
+			log.Warnf("%s is unavailable, not rebalancing it", c.Prefix)
+			continue
+		}
+		if len(c.Signers()) < 2 {
+			continue
+		}
+		err := rebalanceChain(ctx, c)
+		if err != nil {
+			return fmt.Errorf("rebalancing %s: %w", c.Prefix, err)
+		}
+	}
+	return nil
+}
+
+func rebalanceChain(ctx context.Context, c *chain.Chain) error {
+	coins, err := cosmostypes.ParseCoinsNormalized(funding[c.Prefix].Coins)
+	if err != nil {
+		return err
+	}
+	fees, err := cosmostypes.ParseCoinsNormalized(funding[c.Prefix].Fees)
+	if err != nil {
+		return err
+	}
+	signers := c.Signers()
+	balances := make([]cosmostypes.Coins, len(signers))
+	for i, signer := range signers {
+		balances[i], err = c.Balance(ctx, signer.Address)
+		if err != nil {
+			return err
+		}
+	}
+
+	var toAddrs []cosmostypes.AccAddress
+	var topUps []cosmostypes.Coins
+	var batchFees cosmostypes.Coins
+	for i, signer := range signers[1:] {
+		topUp := cosmostypes.NewCoins()
+		for _, coin := range coins {
+			total := cosmostypes.ZeroInt()
+			for _, balance := range balances {
+				total = total.Add(balance.AmountOf(coin.Denom))
+			}
+			share := total.QuoRaw(int64(len(signers)))
+			missing := share.Sub(balances[i+1].AmountOf(coin.Denom))
+			if missing.IsPositive() {
+				topUp = topUp.Add(cosmostypes.NewCoin(coin.Denom, missing))
+			}
+		}
+		if topUp.IsZero() {
+			continue
+		}
+		addr, err := c.DecodeAddr(signer.Address)
+		if err != nil {
+			return err
+		}
+		log.Infof("%s topping up signer %d [%s] with %s", c.Prefix, signer.Index, signer.Address, topUp)
+		toAddrs = append(toAddrs, addr)
+		topUps = append(topUps, topUp)
+		batchFees = batchFees.Add(fees...)
+	}
+	if len(toAddrs) == 0 {
+		log.Infof("%s signers are balanced", c.Prefix)
+		return nil
+	}
+
+	res, err := c.MultiSend(signers[0], toAddrs, topUps, batchFees, nil)
+	if err != nil {
+		return err
+	}
+	res, err = c.WaitForTx(res.TxHash)
+	if err != nil {
+		return err
+	}
+	log.Infof("%s rebalanced %d signers in block %d, tx %s", c.Prefix, len(toAddrs), res.Height, res.TxHash)
+	return nil
+}
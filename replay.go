@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xiti922/fonzie/customlens"
+	"github.com/xiti922/fonzie/db"
+)
+
+const (
+	// replayRetryDelay is how long a replayed request waits for room in a
+	// full worker queue
+	replayRetryDelay = time.Second * 5
+	// replayLease is how long a faucet process holds the pending requests
+	// it funded or claimed, before another process may replay them
+	replayLease = time.Minute * 5
+	// releaseTimeout bounds the release of the pending requests on shutdown
+	releaseTimeout = time.Second * 10
+)
+
+// keepReplaying replays the pending requests of stopped faucet processes on
+// startup, and then whenever the lease of a crashed process may have expired
+func (fh FaucetHandler) keepReplaying(ctx context.Context) {
+	t := time.NewTicker(replayLease)
+	defer t.Stop()
+	for {
+		if err := fh.replayPendingReceipts(ctx); err != nil {
+			log.Error(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// replayPendingReceipts picks up the requests that were queued or broadcast
+// when another faucet process stopped. Broadcast requests are only queued
+// again once their tx can't be committed anymore, so nobody is paid twice.
+func (fh FaucetHandler) replayPendingReceipts(ctx context.Context) error {
+	receipts, err := fh.db.ListFundingReceipts(ctx, db.ReceiptQuery{
+		// this process sends the requests funded since it started
+		Before:   fh.startedAt,
+		Statuses: []db.ReceiptStatus{db.ReceiptQueued, db.ReceiptBroadcast},
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var claimed int
+	queued := make(map[string]db.FundingReceipts)
+	byTx := make(map[string]db.FundingReceipts)
+	for _, receipt := range receipts {
+		if receipt.Owner == fh.instance || receipt.ClaimedAt.After(now.Add(-replayLease)) {
+			continue
+		}
+		// other faucet processes may replay the same receipts, e.g. during
+		// a rolling deploy, only the one that claims a receipt sends it
+		err := fh.db.ClaimFundingReceipt(ctx, receipt.ID, db.ReceiptClaim{
+			Status:        receipt.Status,
+			PreviousOwner: receipt.Owner,
+			Owner:         fh.instance,
+			ClaimedAt:     now,
+		})
+		if errors.Is(err, db.ErrReceiptClaimed) {
+			continue
+		}
+		if err != nil {
+			log.Errorf("claiming replayed request %s: %v", receipt.ID, err)
+			continue
+		}
+		receipt.Owner = fh.instance
+		claimed += 1
+
+		if _, ok := fh.faucets[receipt.ChainPrefix]; !ok {
+			fh.failReceipt(ctx, receipt, fmt.Errorf("%s chain prefix is not supported", receipt.ChainPrefix))
+			continue
+		}
+		if receipt.Status == db.ReceiptBroadcast && receipt.TxHash != "" {
+			byTx[receipt.TxHash] = append(byTx[receipt.TxHash], receipt)
+			continue
+		}
+		queued[receipt.ChainPrefix] = append(queued[receipt.ChainPrefix], receipt)
+	}
+	if claimed > 0 {
+		log.Infof("replaying %d pending requests", claimed)
+	}
+	// a full queue only holds up the replay of its own chain
+	for prefix, batch := range queued {
+		go fh.requeue(ctx, fh.faucets[prefix], batch)
+	}
+	for hash, batch := range byTx {
+		go fh.settleReplayedTx(ctx, fh.faucets[batch[0].ChainPrefix], hash, batch)
+	}
+	return nil
+}
+
+// settleReplayedTx waits for the tx of a batch that was broadcast before the
+// restart. Its requests are only queued again when the tx failed or provably
+// can't be committed anymore, a pending tx is broadcast again instead.
+func (fh FaucetHandler) settleReplayedTx(ctx context.Context, faucet ChainFaucet, hash string, batch db.FundingReceipts) {
+	receipt := batch[0]
+	if receipt.Signer == "" {
+		// broadcast by a version that did not record the signer, there is
+		// no telling whether the tx can still be committed
+		log.Errorf("%s replayed tx %s has no signer, its %d requests are left broadcast", faucet.chain.Prefix, hash, len(batch))
+		return
+	}
+	select {
+	case <-faucet.chain.Ready():
+	case <-ctx.Done():
+		return
+	}
+	res, err := faucet.chain.SettleTx(ctx, receipt.Signer, customlens.SignedTx{
+		Hash:     hash,
+		Bytes:    receipt.TxBytes,
+		Sequence: uint64(receipt.Sequence),
+	})
+	if err == nil {
+		for _, receipt := range batch {
+			fh.updateReceipt(ctx, receipt.ID, batchOutcome(res, nil))
+		}
+		log.Infof("%s tx %s of %d replayed requests was committed", faucet.chain.Prefix, hash, len(batch))
+		return
+	}
+	if ctx.Err() != nil {
+		// the tx is settled by the next faucet process
+		return
+	}
+	log.Warnf("%s tx %s was not committed, queueing its %d requests again: %v", faucet.chain.Prefix, hash, len(batch), err)
+	fh.requeue(ctx, faucet, batch)
+}
+
+// requeue hands persisted requests to the worker of their chain, waiting
+// while its queue is full. The requesters are not around anymore, so the
+// outcome is only logged.
+func (fh FaucetHandler) requeue(ctx context.Context, faucet ChainFaucet, receipts db.FundingReceipts) {
+	for _, receipt := range receipts {
+		recipient, err := faucet.chain.DecodeAddr(receipt.Recipient)
+		if err != nil {
+			fh.failReceipt(ctx, receipt, err)
+			continue
+		}
+		if !fh.updateReceipt(ctx, receipt.ID, db.ReceiptUpdate{Status: db.ReceiptQueued}) {
+			continue
+		}
+		r := FaucetReq{
+			Recipient:    recipient,
+			Address:      receipt.Recipient,
+			Coins:        receipt.Amount,
+			Fees:         receipt.Fees,
+			receiptID:    receipt.ID,
+			owner:        fh.instance,
+			reservations: receipt.Reservations,
+		}
+		for {
+			if _, ok := faucet.enqueue(r); ok {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				// the remaining receipts stay queued for the next restart
+				return
+			case <-time.After(replayRetryDelay):
+			}
+		}
+	}
+}
+
+func (fh FaucetHandler) failReceipt(ctx context.Context, receipt db.FundingReceipt, err error) {
+	log.Errorf("dropping replayed request %s: %v", receipt.ID, err)
+	if !fh.updateReceipt(ctx, receipt.ID, db.ReceiptUpdate{Status: db.ReceiptFailed, Error: err.Error()}) {
+		return
+	}
+	if len(receipt.Reservations) > 0 {
+		if err := fh.db.ReleaseReservations(ctx, receipt.Reservations...); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// updateReceipt updates a receipt claimed by this process, it returns false
+// when another process claimed it in the meantime
+func (fh FaucetHandler) updateReceipt(ctx context.Context, id string, update db.ReceiptUpdate) bool {
+	update.Owner = fh.instance
+	err := fh.db.UpdateFundingReceipt(ctx, id, update)
+	if errors.Is(err, db.ErrReceiptClaimed) {
+		log.Warnf("replayed request %s: %v", id, err)
+		return false
+	}
+	if err != nil {
+		log.Error(err)
+	}
+	return true
+}
+
+// releaseReceipts hands the pending requests of this process over to the
+// next one on shutdown, so they are replayed without waiting for the lease
+func (fh FaucetHandler) releaseReceipts(ctx context.Context) {
+	receipts, err := fh.db.ListFundingReceipts(ctx, db.ReceiptQuery{
+		Statuses: []db.ReceiptStatus{db.ReceiptQueued, db.ReceiptBroadcast},
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, receipt := range receipts {
+		if receipt.Owner != fh.instance {
+			continue
+		}
+		err := fh.db.ClaimFundingReceipt(ctx, receipt.ID, db.ReceiptClaim{
+			Status:        receipt.Status,
+			PreviousOwner: fh.instance,
+		})
+		if err != nil && !errors.Is(err, db.ErrReceiptClaimed) {
+			log.Error(err)
+		}
+	}
+}
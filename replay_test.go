@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xiti922/fonzie/db"
+)
+
+func TestReplayQueuedReceipts(t *testing.T) {
+	store := db.NewMemoryDb()
+	fh := newTestFaucetHandler(t, store)
+	address := testAddress(t, 3)
+	id, err := store.AppendFundingReceipt(context.Background(), db.FundingReceipt{
+		ChainPrefix:  "umee",
+		Recipient:    address,
+		FundedAt:     fh.startedAt.Add(-time.Minute),
+		Status:       db.ReceiptQueued,
+		Reservations: []string{"reservation"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fh.replayPendingReceipts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-fh.faucets["umee"].channel:
+		if r.receiptID != id || r.Address != address || len(r.reservations) != 1 {
+			t.Errorf("replayed request = %+v, want receipt %s to %s with its reservation", r, id, address)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued receipt was not replayed")
+	}
+}
+
+func TestReplayClaimedOnce(t *testing.T) {
+	store := db.NewMemoryDb()
+	first := newTestFaucetHandler(t, store)
+	second := newTestFaucetHandler(t, store)
+	receipts := []db.FundingReceipt{
+		{Recipient: testAddress(t, 7), FundedAt: first.startedAt.Add(-time.Hour), Owner: "stopped", ClaimedAt: first.startedAt.Add(-time.Hour)},
+		// the lease of a process that may still be running
+		{Recipient: testAddress(t, 8), FundedAt: first.startedAt.Add(-time.Hour), Owner: "running", ClaimedAt: time.Now()},
+		// funded after the replaying processes started
+		{Recipient: testAddress(t, 9), FundedAt: time.Now().Add(time.Second), Owner: "running", ClaimedAt: time.Now().Add(-time.Hour)},
+	}
+	for _, receipt := range receipts {
+		receipt.ChainPrefix = "umee"
+		receipt.Status = db.ReceiptQueued
+		_, err := store.AppendFundingReceipt(context.Background(), receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, fh := range []FaucetHandler{first, second, first} {
+		err := fh.replayPendingReceipts(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var replayed []string
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case r := <-first.faucets["umee"].channel:
+			replayed = append(replayed, r.Address)
+		case r := <-second.faucets["umee"].channel:
+			replayed = append(replayed, r.Address)
+		case <-timeout:
+			done = true
+		}
+	}
+	if len(replayed) != 1 || replayed[0] != receipts[0].Recipient {
+		t.Errorf("replayed %v, want only %s", replayed, receipts[0].Recipient)
+	}
+}
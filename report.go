@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/xiti922/fonzie/db"
+)
+
+// printSpendReport prints how much each chain dispensed since the given time,
+// based on the confirmed receipts of the funding ledger
+func printSpendReport(ctx context.Context, store db.Store, since time.Time) error {
+	receipts, err := store.ListFundingReceipts(ctx, db.ReceiptQuery{
+		Since:    since,
+		Statuses: []db.ReceiptStatus{db.ReceiptIncluded},
+	})
+	if err != nil {
+		return err
+	}
+
+	byChain := make(map[db.ChainPrefix]db.FundingReceipts)
+	for _, r := range receipts {
+		byChain[r.ChainPrefix] = append(byChain[r.ChainPrefix], r)
+	}
+	prefixes := make([]string, 0, len(byChain))
+	for prefix := range byChain {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	fmt.Printf("Spend since %s\n", since.Format(time.RFC3339))
+	for _, prefix := range prefixes {
+		chainReceipts := byChain[prefix]
+		fmt.Printf("%s: %d dispenses, amount %s, fees %s\n",
+			prefix, len(chainReceipts), chainReceipts.TotalAmount(), chainReceipts.TotalFees())
+	}
+	return nil
+}
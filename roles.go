@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// requiredRoles returns the roles (IDs or names) needed to request funds on
+// the chain. Chain specific roles replace the global ROLE_REQUIRED.
+func requiredRoles(prefix string) []string {
+	if roles := funding[prefix].Roles; len(roles) > 0 {
+		return roles
+	}
+	return globalRoles
+}
+
+// guildMember looks up userID in the guild the request came from, or in the
+// configured home guild for direct messages. hint is the partial member
+// Discord sends along with guild events and is used when present.
+func guildMember(s *discordgo.Session, guildID string, userID string, hint *discordgo.Member) (*discordgo.Member, string, error) {
+	if guildID == "" {
+		guildID = homeGuildID
+	} else if hint != nil {
+		return hint, guildID, nil
+	}
+	if guildID == "" {
+		return nil, "", fmt.Errorf("HOME_GUILD_ID is not configured, please send your request in the server")
+	}
+	member, err := s.State.Member(guildID, userID)
+	if err == nil {
+		return member, guildID, nil
+	}
+	member, err = s.GuildMember(guildID, userID)
+	if err != nil {
+		if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Response != nil && restErr.Response.StatusCode == 404 {
+			return nil, guildID, fmt.Errorf("you need to be a member of the %s server", guildName(s, guildID))
+		}
+		return nil, guildID, err
+	}
+	return member, guildID, nil
+}
+
+// guildRoles returns the roles of the guild from the state cache, or from
+// the REST API when the state doesn't know the guild
+func guildRoles(s *discordgo.Session, guildID string) ([]*discordgo.Role, error) {
+	guild, err := s.State.Guild(guildID)
+	if err == nil && len(guild.Roles) > 0 {
+		return guild.Roles, nil
+	}
+	return s.GuildRoles(guildID)
+}
+
+func guildName(s *discordgo.Session, guildID string) string {
+	guild, err := s.State.Guild(guildID)
+	if err == nil && guild.Name != "" {
+		return guild.Name
+	}
+	guild, err = s.Guild(guildID)
+	if err == nil && guild.Name != "" {
+		return guild.Name
+	}
+	return guildID
+}
+
+// memberRoles resolves the role IDs of a member to roles of the guild
+func memberRoles(s *discordgo.Session, guildID string, member *discordgo.Member) ([]*discordgo.Role, error) {
+	roles, err := guildRoles(s, guildID)
+	if err != nil {
+		return nil, err
+	}
+	var out []*discordgo.Role
+	for _, role := range roles {
+		for _, id := range member.Roles {
+			if role.ID == id {
+				out = append(out, role)
+			}
+		}
+	}
+	return out, nil
+}
+
+// hasRole matches a role either by ID or by name
+func hasRole(roles []*discordgo.Role, wanted string) bool {
+	for _, role := range roles {
+		if role.ID == wanted || strings.EqualFold(role.Name, wanted) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRoles returns an error explaining which role is missing if the member
+// may not request funds on the chain
+func checkRoles(s *discordgo.Session, guildID string, member *discordgo.Member, prefix string) error {
+	required := requiredRoles(prefix)
+	if len(required) == 0 {
+		return nil
+	}
+	roles, err := memberRoles(s, guildID, member)
+	if err != nil {
+		return err
+	}
+	for _, wanted := range required {
+		if hasRole(roles, wanted) {
+			return nil
+		}
+	}
+
+	// name the missing roles instead of showing raw IDs
+	all, err := guildRoles(s, guildID)
+	if err != nil {
+		log.Error(err)
+	}
+	names := make([]string, 0, len(required))
+	for _, wanted := range required {
+		name := wanted
+		for _, role := range all {
+			if role.ID == wanted {
+				name = role.Name
+			}
+		}
+		names = append(names, "`"+name+"`")
+	}
+	return fmt.Errorf("you need one of the roles %s in the %s server to request %s funds", strings.Join(names, ", "), guildName(s, guildID), prefix)
+}
+
+// memberTier returns the funding tier of the member's highest role, or the
+// empty default tier if none of the member's roles has a tier on the chain
+func memberTier(s *discordgo.Session, guildID string, member *discordgo.Member, prefix string) (string, error) {
+	tiers := funding[prefix].Tiers
+	if len(tiers) == 0 {
+		return "", nil
+	}
+	roles, err := memberRoles(s, guildID, member)
+	if err != nil {
+		return "", err
+	}
+	var best *discordgo.Role
+	for _, role := range roles {
+		if _, ok := tiers[role.ID]; !ok {
+			continue
+		}
+		if best == nil || role.Position > best.Position {
+			best = role
+		}
+	}
+	if best == nil {
+		return "", nil
+	}
+	return best.ID, nil
+}
@@ -1,51 +1,141 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/bwmarrin/discordgo"
 	"github.com/cosmos/cosmos-sdk/types"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/xiti922/fonzie/chain"
+	"github.com/xiti922/fonzie/customlens"
+	"github.com/xiti922/fonzie/db"
 )
 
 /*
  * 1. create a worker -> a go routine which will consume a channel
  * 2. the worker will wait for new requests and have a time guard for processing faucet requests
- *   - we will batch requests in 4s, max 50 requests per transactions
+ *   - we will batch requests in 7s, max 160 requests per transactions, unless the chain
+ *     configures its own batch window, size and gas
  *
  */
 
 type FaucetReq struct {
 	Recipient types.AccAddress
+	// Address is the bech32 encoded recipient
+	Address   string
 	Coins     types.Coins
 	Fees      types.Coins
-	session   *discordgo.Session
-	msg       *discordgo.MessageCreate
+	receiptID string
+	// owner is the faucet process holding the receipt, the ledger is only
+	// updated while it still does
+	owner string
+	// reservations are released when the request fails, so the cooldown
+	// only applies to funds that were actually dispensed
+	reservations []string
+	notifier     Notifier
+}
+
+// Notifier tells the requester about the outcome of their request
+type Notifier interface {
+	// Dispensed is called once the tx is committed
+	Dispensed(r FaucetReq, res *types.TxResponse)
+	Failed(r FaucetReq, err error)
 }
 
 type ChainFaucet struct {
 	channel chan FaucetReq
 	chain   *chain.Chain
+	db      db.Store
+	// pending counts the requests queued or waiting in the current batch
+	pending *int64
+	// pipelines send the batches, one per signer of the chain. Consume sets
+	// them up once the chain is connected.
+	pipelines []signerPipeline
+}
+
+type signerPipeline struct {
+	signer  *chain.Signer
+	batches chan []FaucetReq
+	// load counts the requests in batches handed to the signer
+	load *int64
+}
+
+const (
+	defaultQueueSize   = 1000
+	defaultBatchWindow = time.Second * 7
+	defaultBatchSize   = 160
+)
+
+func NewChainFaucet(c *chain.Chain, store db.Store) ChainFaucet {
+	size := c.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	return ChainFaucet{make(chan FaucetReq, size), c, store, new(int64), nil}
+}
+
+// enqueue hands a request to the worker without waiting on it and returns
+// its position in the queue, or false when the queue is full
+func (cf ChainFaucet) enqueue(r FaucetReq) (int64, bool) {
+	position := atomic.AddInt64(cf.pending, 1)
+	select {
+	case cf.channel <- r:
+		return position, true
+	default:
+		atomic.AddInt64(cf.pending, -1)
+		return 0, false
+	}
 }
 
+// Consume batches the queued requests until quit is closed. It then sends
+// the requests left in the queue and returns once every broadcast tx is
+// settled. Requests the shutdown grace period cuts off are replayed from the
+// ledger by the next faucet process.
 func (cf ChainFaucet) Consume(quit chan bool) {
+	select {
+	case <-cf.chain.Ready():
+	case <-quit:
+		return
+	}
 	log.Info("starting worker ", cf.chain.Prefix)
+	for _, signer := range cf.chain.Signers() {
+		cf.pipelines = append(cf.pipelines, signerPipeline{signer, make(chan []FaucetReq, 1), new(int64)})
+	}
+	var pipelines sync.WaitGroup
+	for _, p := range cf.pipelines {
+		pipelines.Add(1)
+		go func(p signerPipeline) {
+			defer pipelines.Done()
+			cf.runPipeline(p)
+		}(p)
+	}
 	var r FaucetReq
 	var rs []FaucetReq
-	const interval = time.Second * 7
+	var gas batchGasMeter
+	var interval = cf.batchWindow()
 	var t = time.NewTicker(interval)
 
 	for {
 		select {
 		case r = <-cf.channel:
 			log.Infof("%s worker NEW request, req: %v", cf.chain.Prefix, r)
+			if len(rs) > 0 && cf.exceedsBatchGas(&gas, append(rs, r)) {
+				log.Infof("%s worker batch gas reached, #num req: %d", cf.chain.Prefix, len(rs))
+				cf.dispatch(rs)
+				rs = make([]FaucetReq, 0)
+				gas = batchGasMeter{}
+				t.Reset(interval)
+			}
 			rs = append(rs, r)
-			if len(rs) > 160 {
-				cf.processRequests(rs)
+			if len(rs) >= cf.batchSize() {
+				cf.dispatch(rs)
 				rs = make([]FaucetReq, 0)
+				gas = batchGasMeter{}
 				if isDebug {
 					log.Infof("DEBUG: %s worker processed request, req: %v", cf.chain.Prefix, r)
 				}
@@ -56,21 +146,138 @@ func (cf ChainFaucet) Consume(quit chan bool) {
 		case <-t.C:
 			log.Infof("%s worker ticker, #num req: %d", cf.chain.Prefix, len(rs))
 			if len(rs) > 0 {
-				cf.processRequests(rs)
+				cf.dispatch(rs)
 				rs = make([]FaucetReq, 0)
+				gas = batchGasMeter{}
 			}
 
 		case <-quit:
+			t.Stop()
+			// the frontends stopped accepting requests, so the queue only
+			// shrinks from here
+			rs = cf.drain(rs, gas)
 			if len(rs) > 0 {
-				cf.processRequests(rs)
+				log.Infof("%s worker flushing %d requests", cf.chain.Prefix, len(rs))
+				cf.dispatch(rs)
 			}
-			// die so kubernetes restarts pod
-			log.Fatal("Worker ", cf.chain.Prefix, " quit")
+			for _, p := range cf.pipelines {
+				close(p.batches)
+			}
+			pipelines.Wait()
+			log.Info("stopped worker ", cf.chain.Prefix)
+			return
 		}
 	}
 }
 
-func (cf ChainFaucet) processRequests(rs []FaucetReq) {
+// drain dispatches the queued requests in full batches without waiting for
+// new ones, and returns the last batch that is not full
+func (cf ChainFaucet) drain(rs []FaucetReq, gas batchGasMeter) []FaucetReq {
+	for {
+		select {
+		case r := <-cf.channel:
+			if len(rs) > 0 && cf.exceedsBatchGas(&gas, append(rs, r)) {
+				cf.dispatch(rs)
+				rs = make([]FaucetReq, 0)
+				gas = batchGasMeter{}
+			}
+			rs = append(rs, r)
+			if len(rs) >= cf.batchSize() {
+				log.Infof("%s worker draining %d requests", cf.chain.Prefix, len(rs))
+				cf.dispatch(rs)
+				rs = make([]FaucetReq, 0)
+				gas = batchGasMeter{}
+			}
+		default:
+			return rs
+		}
+	}
+}
+
+// dispatch hands a batch to the signer with the fewest requests in flight
+func (cf ChainFaucet) dispatch(rs []FaucetReq) {
+	p := cf.pipelines[0]
+	for _, candidate := range cf.pipelines[1:] {
+		if atomic.LoadInt64(candidate.load) < atomic.LoadInt64(p.load) {
+			p = candidate
+		}
+	}
+	atomic.AddInt64(p.load, int64(len(rs)))
+	p.batches <- rs
+}
+
+func (cf ChainFaucet) runPipeline(p signerPipeline) {
+	var inflight sync.WaitGroup
+	for rs := range p.batches {
+		wg := cf.processRequests(p.signer, rs)
+		inflight.Add(1)
+		go func(n int64) {
+			defer inflight.Done()
+			wg.Wait()
+			atomic.AddInt64(p.load, -n)
+			atomic.AddInt64(cf.pending, -n)
+		}(int64(len(rs)))
+	}
+	// the batches channel is closed on shutdown, wait for the batches that
+	// are still waiting for inclusion
+	inflight.Wait()
+}
+
+func (cf ChainFaucet) batchWindow() time.Duration {
+	if cf.chain.BatchWindow.Duration > 0 {
+		return cf.chain.BatchWindow.Duration
+	}
+	return defaultBatchWindow
+}
+
+func (cf ChainFaucet) batchSize() int {
+	if cf.chain.BatchSize > 0 {
+		return cf.chain.BatchSize
+	}
+	return defaultBatchSize
+}
+
+// batchGasMeter remembers the last simulation of the pending batch
+type batchGasMeter struct {
+	// gas is the simulated gas of the first requests of the batch
+	gas      uint64
+	requests int
+}
+
+// estimate extrapolates the gas of the batch from the last simulation. The
+// average gas per request includes the tx overhead, so it overestimates.
+func (m batchGasMeter) estimate(requests int) (uint64, bool) {
+	if m.requests == 0 {
+		return 0, false
+	}
+	perRequest := m.gas / uint64(m.requests)
+	return m.gas + perRequest*uint64(requests-m.requests), true
+}
+
+// exceedsBatchGas reports whether the batch needs more gas than the chain
+// allows for one batch. It only simulates the batch when the estimate from
+// the last simulation comes close to the limit.
+func (cf ChainFaucet) exceedsBatchGas(m *batchGasMeter, rs []FaucetReq) bool {
+	if cf.chain.BatchGas == 0 {
+		return false
+	}
+	if estimate, ok := m.estimate(len(rs)); ok && estimate <= cf.chain.BatchGas {
+		return false
+	}
+	toAddrss, coins, _ := batchOutputs(rs)
+	gas, err := cf.chain.EstimateMultiSendGas(toAddrss, coins)
+	if err != nil {
+		// the batch will report the error when it is sent, don't simulate
+		// it again for every request
+		log.Errorf("%s worker could not simulate batch: %v", cf.chain.Prefix, err)
+		m.gas, m.requests = 0, len(rs)
+		return false
+	}
+	m.gas, m.requests = gas, len(rs)
+	return gas > cf.chain.BatchGas
+}
+
+func batchOutputs(rs []FaucetReq) ([]types.AccAddress, []types.Coins, types.Coins) {
 	var toAddrss = make([]types.AccAddress, 0, len(rs))
 	var coins = make([]types.Coins, 0, len(rs))
 	var fees = make(types.Coins, 0, len(rs))
@@ -79,21 +286,150 @@ func (cf ChainFaucet) processRequests(rs []FaucetReq) {
 		coins = append(coins, r.Coins)
 		fees = fees.Add(r.Fees...)
 	}
-	err := cf.chain.MultiSend(toAddrss, coins, fees)
+	return toAddrss, coins, fees
+}
+
+// processRequests broadcasts the batch with the signer and returns once it
+// is in the mempool. The WaitGroup is done when every request is settled.
+func (cf ChainFaucet) processRequests(signer *chain.Signer, rs []FaucetReq) *sync.WaitGroup {
+	wg := new(sync.WaitGroup)
+	cf.sendBatch(signer, rs, wg)
+	return wg
+}
+
+// sendBatch broadcasts the requests in one transaction and waits for its
+// inclusion in the background, so the signer can broadcast the next batch
+// in the same block.
+func (cf ChainFaucet) sendBatch(signer *chain.Signer, rs []FaucetReq, wg *sync.WaitGroup) {
+	toAddrss, coins, fees := batchOutputs(rs)
+	// the receipts are marked broadcast before the tx is, so a restart in
+	// between never replays requests whose tx may be committed
+	var broadcast customlens.SignedTx
+	res, err := cf.chain.MultiSend(signer, toAddrss, coins, fees, func(signed customlens.SignedTx) error {
+		broadcast = signed
+		return cf.markBroadcast(rs, signer.Address, signed)
+	})
+	if err != nil {
+		cf.settleBatch(signer, rs, res, err, wg)
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// a tx that is not committed in time may still be, until then the
+		// receipts stay broadcast and the requests keep their reservations
+		res, err := cf.chain.SettleTx(context.Background(), signer.Address, broadcast)
+		cf.settleBatch(signer, rs, res, err, wg)
+	}()
+}
+
+// settleBatch records the outcome of a batch and notifies the requesters. A
+// failed batch is split in halves that are sent on their own, so only the
+// requests that fail by themselves are reported as failed.
+func (cf ChainFaucet) settleBatch(signer *chain.Signer, rs []FaucetReq, res *types.TxResponse, err error, wg *sync.WaitGroup) {
+	// splitting the batch only helps when a recipient is at fault, not when
+	// the node or the faucet account is, e.g. with insufficient funds
+	if err != nil && len(rs) > 1 && chain.IsRecipientError(res, err) {
+		log.Warnf("%s worker batch of %d requests failed, retrying in halves: %v", cf.chain.Prefix, len(rs), err)
+		half := len(rs) / 2
+		cf.sendBatch(signer, rs[:half], wg)
+		cf.sendBatch(signer, rs[half:], wg)
+		return
+	}
+	if err != nil && len(rs) == 1 {
+		err = fmt.Errorf("sending to %s: %w", rs[0].Address, err)
+	}
 	if err != nil {
-		for _, r := range rs {
-			reportError(r.session, r.msg, err)
+		log.Errorf("%s worker batch of %d requests failed: %v", cf.chain.Prefix, len(rs), err)
+	}
+	update := batchOutcome(res, err)
+	for _, r := range rs {
+		if !cf.updateReceipt(r, update) {
+			// another faucet process replays the request
+			continue
 		}
-	} else {
-		for _, r := range rs {
-			if isDebug {
-				log.Infof("DEBUG: %s worker processed request, req: %v", cf.chain.Prefix, r)
-			}
-			// Everything worked, so-- respond successfully to Discord requester
-			if (r.session != nil) && (r.msg != nil) {
-				sendReaction(r.session, r.msg, "✅")
-				sendMessage(r.session, r.msg, fmt.Sprintf("Dispensed 💸 `%s` to `%s`", r.Coins, r.Recipient))
-			}
+		if err != nil {
+			cf.releaseReservations(r)
+			notifyFailed(r, fmt.Errorf("%w (nothing was dispensed, you can request again right away)", err))
+			continue
 		}
+		if isDebug {
+			log.Infof("DEBUG: %s worker processed request, req: %v", cf.chain.Prefix, r)
+		}
+		if r.notifier != nil {
+			r.notifier.Dispensed(r, res)
+		}
+	}
+}
+
+func notifyFailed(r FaucetReq, err error) {
+	if r.notifier == nil {
+		log.Error(err)
+		return
+	}
+	r.notifier.Failed(r, err)
+}
+
+// batchOutcome returns the ledger update for the result of a batch
+func batchOutcome(res *types.TxResponse, err error) db.ReceiptUpdate {
+	update := db.ReceiptUpdate{Status: db.ReceiptIncluded}
+	if res != nil {
+		update.TxHash = res.TxHash
+		update.Height = res.Height
+	}
+	if err != nil {
+		update.Status = db.ReceiptFailed
+		update.Error = err.Error()
+	}
+	return update
+}
+
+// updateReceipt records the outcome of a request in the funding ledger. It
+// returns false when the receipt was claimed by another faucet process.
+func (cf ChainFaucet) updateReceipt(r FaucetReq, update db.ReceiptUpdate) bool {
+	if r.receiptID == "" {
+		return true
+	}
+	update.Owner = r.owner
+	err := cf.db.UpdateFundingReceipt(context.Background(), r.receiptID, update)
+	if errors.Is(err, db.ErrReceiptClaimed) {
+		log.Warnf("%s worker: request %s: %v", cf.chain.Prefix, r.receiptID, err)
+		return false
+	}
+	if err != nil {
+		log.Error(err)
+	}
+	return true
+}
+
+// markBroadcast records the tx that is about to be broadcast. It fails when
+// a receipt was claimed by another faucet process, so only one of them sends
+// the request.
+func (cf ChainFaucet) markBroadcast(rs []FaucetReq, signer string, signed customlens.SignedTx) error {
+	for _, r := range rs {
+		if r.receiptID == "" {
+			continue
+		}
+		err := cf.db.UpdateFundingReceipt(context.Background(), r.receiptID, db.ReceiptUpdate{
+			Status:   db.ReceiptBroadcast,
+			TxHash:   signed.Hash,
+			Signer:   signer,
+			Sequence: int64(signed.Sequence),
+			TxBytes:  signed.Bytes,
+			Owner:    r.owner,
+		})
+		if err != nil {
+			return fmt.Errorf("recording tx %s: %w", signed.Hash, err)
+		}
+	}
+	return nil
+}
+
+func (cf ChainFaucet) releaseReservations(r FaucetReq) {
+	if len(r.reservations) == 0 {
+		return
+	}
+	if err := cf.db.ReleaseReservations(context.Background(), r.reservations...); err != nil {
+		log.Error(err)
 	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/xiti922/fonzie/chain"
+	"github.com/xiti922/fonzie/db"
+)
+
+func TestBatchGasMeterEstimate(t *testing.T) {
+	tests := []struct {
+		name     string
+		meter    batchGasMeter
+		requests int
+		want     uint64
+		ok       bool
+	}{
+		{"not simulated", batchGasMeter{}, 3, 0, false},
+		{"simulated batch", batchGasMeter{gas: 300000, requests: 2}, 2, 300000, true},
+		{"extrapolated by the average request", batchGasMeter{gas: 300000, requests: 2}, 5, 750000, true},
+		{"failed simulation", batchGasMeter{gas: 0, requests: 4}, 9, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.meter.estimate(tt.requests)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("estimate(%d) = %d, %v, want %d, %v", tt.requests, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	cf := NewChainFaucet(&chain.Chain{Prefix: "umee", BatchSize: 2}, db.NewMemoryDb())
+	batches := make(chan []FaucetReq, 10)
+	cf.pipelines = []signerPipeline{{batches: batches, load: new(int64)}}
+	for i := 0; i < 4; i++ {
+		if _, ok := cf.enqueue(FaucetReq{Address: testAddress(t, byte(i))}); !ok {
+			t.Fatal("queue is full")
+		}
+	}
+
+	rs := cf.drain([]FaucetReq{{Address: testAddress(t, 9)}}, batchGasMeter{})
+	close(batches)
+	var sizes []int
+	for batch := range batches {
+		sizes = append(sizes, len(batch))
+	}
+	if len(sizes) != 2 || sizes[0] != 2 || sizes[1] != 2 {
+		t.Errorf("dispatched batches of %v requests, want [2 2]", sizes)
+	}
+	if len(rs) != 1 {
+		t.Errorf("drain() left %d requests, want 1", len(rs))
+	}
+}